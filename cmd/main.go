@@ -1,19 +1,180 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ajablonsk1/gload-balancer/internal/config"
 	lb "github.com/ajablonsk1/gload-balancer/pkg/load_balancer"
 )
 
+const usage = `Usage: gload-balancer <command> [flags]
+
+Commands:
+  serve                    start load balancer (default when no command is given)
+  validate                 load config, build server pool and strategy and report all problems
+  print-effective-config   print config with all defaults filled in and secrets redacted
+
+Run gload-balancer <command> -h to see flags of the command.
+`
+
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	configPath := flag.String("path", "", "Path to config file")
-	if *configPath == "" {
-		panic("You must provide flag with path to config file")
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		return serve(args, stderr)
+	case "validate":
+		return validate(args, stdout, stderr)
+	case "print-effective-config":
+		return printEffectiveConfig(args, stdout, stderr)
+	case "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+}
+
+type configFlags struct {
+	path   *string
+	format *string
+}
+
+func newFlagSet(name string, stderr io.Writer) (*flag.FlagSet, configFlags) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags, configFlags{
+		path:   flags.String("path", "", "Path to config file"),
+		format: flags.String("format", "", "Config file format: json, yaml or toml (detected from file extension by default)"),
+	}
+}
+
+// parseFlags parses flags of a command and checks the required ones. Errors are already reported
+// to the output of flag set when it returns.
+func parseFlags(flags *flag.FlagSet, c configFlags, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if flags.NArg() > 0 {
+		err = fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	} else if *c.path == "" {
+		err = errors.New("you must provide flag with path to config file")
+	}
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+	}
+	return err
+}
+
+func usageExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+func serve(args []string, stderr io.Writer) int {
+	flags, c := newFlagSet("serve", stderr)
+	if err := parseFlags(flags, c, args); err != nil {
+		return usageExitCode(err)
+	}
+
+	loadBalancer, err := lb.NewLoadBalancerFormat(*c.path, *c.format)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := loadBalancer.Shutdown(ctx); err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+		close(stopped)
+	}()
+
+	if err := loadBalancer.Start(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	<-stopped
+	return 0
+}
+
+func validate(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags, c := newFlagSet("validate", stderr)
+	if err := parseFlags(flags, c, args); err != nil {
+		return usageExitCode(err)
+	}
+
+	if err := checkConfig(*c.path, *c.format); err != nil {
+		var errs config.ValidationErrors
+		if errors.As(err, &errs) {
+			for _, fieldErr := range errs {
+				fmt.Fprintln(stderr, fieldErr.Error())
+			}
+		} else {
+			fmt.Fprintln(stderr, err)
+		}
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "%s: config is valid\n", *c.path)
+	return 0
+}
+
+// checkConfig goes through every step of load balancer creation which can fail, without starting it.
+func checkConfig(path string, format string) error {
+	_, err := lb.NewLoadBalancerFormat(path, format)
+	return err
+}
+
+func printEffectiveConfig(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags, c := newFlagSet("print-effective-config", stderr)
+	output := flags.String("output", config.FormatJSON, "Output format: json, yaml or toml")
+	if err := parseFlags(flags, c, args); err != nil {
+		return usageExitCode(err)
+	}
+
+	effective, err := config.GetConfigFormat(*c.path, *c.format)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
 
-	if loadBalancer, err := lb.NewLoadBalancer(*configPath); err != nil {
-		panic(err.Error())
-	} else {
-		loadBalancer.Start()
+	data, err := config.Marshal(effective, *output)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
+	stdout.Write(data)
+	return 0
 }
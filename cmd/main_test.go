@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ajablonsk1/gload-balancer/pkg/strategy"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("valid config", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run([]string{"validate", "-path", "../config/config.json"}, &stdout, &stderr)
+
+		if code != 0 {
+			t.Errorf("wrong exit code. got %d want 0, stderr: %s", code, stderr.String())
+		}
+	})
+
+	t.Run("reports every problem on its own line", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		content := `{"strategy": "nope", "servers": [{"host": ""}]}`
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+
+		code := run([]string{"validate", "-path", path}, &stdout, &stderr)
+
+		if code != 1 {
+			t.Errorf("wrong exit code. got %d want 1", code)
+		}
+		got := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		want := []string{
+			"address: missing",
+			`strategy: unknown strategy "nope", must be one of ` + strings.Join(strategy.Names(), ", "),
+			"servers[0].host: missing",
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("wrong output. got %q want %q", got, want)
+		}
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		if code := run([]string{"validate"}, &stdout, &stderr); code != 2 {
+			t.Errorf("wrong exit code. got %d want 2", code)
+		}
+	})
+}
+
+func TestPrintEffectiveConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "address: localhost:8080\nservers:\n  - host: localhost:1111\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"print-effective-config", "-path", path, "-output", "yaml"}, &stdout, &stderr)
+
+	if code != 0 {
+		t.Fatalf("wrong exit code. got %d want 0, stderr: %s", code, stderr.String())
+	}
+	got := stdout.String()
+	want := "address: localhost:8080\n" +
+		"no_healthy_upstream:\n    body: |\n        no healthy upstream\n    content_type: text/plain; charset=utf-8\n    status_code: 503\n" +
+		"servers:\n    - host: localhost:1111\n      scheme: http\n      weight: 1\n" +
+		"sticky:\n    enabled: true\n    idle_ttl: 10m0s\n    mode: address\n    on_unhealthy: failover\n" +
+		"strategy: round-robin\n"
+	if got != want {
+		t.Errorf("wrong output. got %q want %q", got, want)
+	}
+}
+
+func TestPrintEffectiveConfigSecrets(t *testing.T) {
+	t.Setenv("GLOAD_TEST_SECRET", "topsecret")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+address: localhost:8080
+pools:
+  api:
+    sticky:
+      mode: cookie
+      cookie:
+        secret: literalsecret
+    servers:
+      - host: localhost:2222
+sticky:
+  mode: cookie
+  cookie:
+    secret: ${GLOAD_TEST_SECRET}
+servers:
+  - host: localhost:1111
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []string{"json", "yaml", "toml"} {
+		var stdout, stderr bytes.Buffer
+
+		code := run([]string{"print-effective-config", "-path", path, "-output", output}, &stdout, &stderr)
+
+		if code != 0 {
+			t.Fatalf("wrong exit code. got %d want 0, stderr: %s", code, stderr.String())
+		}
+		got := stdout.String()
+		if strings.Contains(got, "topsecret") || strings.Contains(got, "literalsecret") {
+			t.Errorf("secret printed in %s output: %s", output, got)
+		}
+		if !strings.Contains(got, "${GLOAD_TEST_SECRET}") || !strings.Contains(got, "<redacted>") {
+			t.Errorf("reference or redacted secret missing in %s output: %s", output, got)
+		}
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := run([]string{"deploy"}, &stdout, &stderr); code != 2 {
+		t.Errorf("wrong exit code. got %d want 2", code)
+	}
+}
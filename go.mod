@@ -2,4 +2,8 @@ module github.com/ajablonsk1/gload-balancer
 
 go 1.19
 
-require go.uber.org/atomic v1.11.0 // indirect
+require (
+	github.com/BurntSushi/toml v1.4.0
+	go.uber.org/atomic v1.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,89 @@
+package certificates
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/ajablonsk1/gload-balancer/internal/watcher"
+)
+
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Store holds certificates served by TLS listener and picks one of them for each client based on SNI.
+// Certificates can be reloaded from disk while listener is running.
+type Store struct {
+	pairs        []KeyPair
+	certificates atomic.Pointer[[]tls.Certificate]
+}
+
+func NewStore(pairs []KeyPair) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("at least one certificate must be provided")
+	}
+	s := &Store{pairs: pairs}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload loads all certificates from disk again. If any of them fails to load, previously loaded
+// certificates stay in use.
+func (s *Store) Reload() error {
+	certificates := make([]tls.Certificate, 0, len(s.pairs))
+	for _, pair := range s.pairs {
+		certificate, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate %s: %w", pair.CertFile, err)
+		}
+		if certificate.Leaf == nil {
+			leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+			if err != nil {
+				return fmt.Errorf("loading certificate %s: %w", pair.CertFile, err)
+			}
+			certificate.Leaf = leaf
+		}
+		certificates = append(certificates, certificate)
+	}
+	s.certificates.Store(&certificates)
+	return nil
+}
+
+// GetCertificate returns the first certificate valid for server name requested by client.
+// When client does not send SNI or no certificate matches, the first certificate is returned.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificates := *s.certificates.Load()
+	if hello.ServerName != "" {
+		for i := range certificates {
+			if certificates[i].Leaf.VerifyHostname(hello.ServerName) == nil {
+				return &certificates[i], nil
+			}
+		}
+	}
+	return &certificates[0], nil
+}
+
+// Watch reloads certificates every time any of their files changes. It blocks until ctx is done.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	paths := make([]string, 0, 2*len(s.pairs))
+	for _, pair := range s.pairs {
+		paths = append(paths, pair.CertFile, pair.KeyFile)
+	}
+
+	watcher.NewFileWatcher(paths...).Watch(ctx, interval, func() {
+		if err := s.Reload(); err != nil {
+			log.Printf("certificate reload rejected, keeping old certificates: %s", err)
+			return
+		}
+		log.Printf("certificates reloaded")
+	})
+}
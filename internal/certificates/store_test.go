@@ -0,0 +1,144 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes self-signed certificate for given names and returns paths of its files.
+func writeKeyPair(t *testing.T, dir string, name string, serial int64, dnsNames ...string) KeyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair := KeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func TestStoreGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore([]KeyPair{
+		writeKeyPair(t, dir, "a", 1, "a.example.com"),
+		writeKeyPair(t, dir, "b", 2, "b.example.com", "*.b.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("error creating store: %s", err)
+	}
+
+	tests := map[string]int64{
+		"a.example.com":     1,
+		"b.example.com":     2,
+		"api.b.example.com": 2,
+		"unknown.com":       1,
+		"":                  1,
+	}
+	for serverName, want := range tests {
+		certificate, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			t.Fatalf("error getting certificate: %s", err)
+		}
+		if got := certificate.Leaf.SerialNumber.Int64(); got != want {
+			t.Errorf("wrong certificate for %q. got %d want %d", serverName, got, want)
+		}
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeKeyPair(t, dir, "a", 1, "a.example.com")
+	store, err := NewStore([]KeyPair{pair})
+	if err != nil {
+		t.Fatalf("error creating store: %s", err)
+	}
+
+	writeKeyPair(t, dir, "a", 2, "a.example.com")
+	if err := store.Reload(); err != nil {
+		t.Fatalf("error reloading store: %s", err)
+	}
+	certificate, _ := store.GetCertificate(&tls.ClientHelloInfo{})
+	if got := certificate.Leaf.SerialNumber.Int64(); got != 2 {
+		t.Errorf("certificate was not reloaded. got serial %d want 2", got)
+	}
+
+	if err := os.WriteFile(pair.KeyFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Errorf("expected error when reloading broken key")
+	}
+	certificate, _ = store.GetCertificate(&tls.ClientHelloInfo{})
+	if got := certificate.Leaf.SerialNumber.Int64(); got != 2 {
+		t.Errorf("broken certificate replaced the old one. got serial %d want 2", got)
+	}
+}
+
+func TestStoreServesTLS(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeKeyPair(t, dir, "a", 1, "a.example.com")
+	store, err := NewStore([]KeyPair{pair, writeKeyPair(t, dir, "b", 2, "b.example.com")})
+	if err != nil {
+		t.Fatalf("error creating store: %s", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: store.GetCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "b.example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer conn.Close()
+
+	if got := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); got != 2 {
+		t.Errorf("wrong certificate served. got serial %d want 2", got)
+	}
+}
@@ -0,0 +1,132 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+	HeaderForwarded     = "Forwarded"
+)
+
+// DefaultHeaders are headers read from trusted proxies when none are configured.
+var DefaultHeaders = []string{HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded}
+
+// Resolver finds address of the client which sent request. Headers with client address are
+// believed only when request comes from a trusted proxy, otherwise any client could pick its
+// address. Nil Resolver trusts nobody.
+type Resolver struct {
+	trusted []netip.Prefix
+	// headers are canonical header names in order of preference
+	headers []string
+}
+
+func NewResolver(trusted []netip.Prefix, headers []string) *Resolver {
+	canonical := make([]string, len(headers))
+	for i, header := range headers {
+		canonical[i] = http.CanonicalHeaderKey(header)
+	}
+	return &Resolver{trusted: trusted, headers: canonical}
+}
+
+// RemoteIP returns address of remoteAddr without port.
+func RemoteIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return strings.Trim(remoteAddr, "[]")
+}
+
+func (r *Resolver) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns address of the client. When request comes from a trusted proxy, the first
+// configured header present in request is used. Addresses in headers listing a chain of proxies
+// are walked from the closest one and the first address which is not trusted is the client.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	peer := RemoteIP(req.RemoteAddr)
+	if r == nil || !r.isTrusted(peer) {
+		return peer
+	}
+
+	for _, header := range r.headers {
+		values := req.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+		var chain []string
+		switch header {
+		case HeaderXForwardedFor:
+			chain = forwardedForChain(values)
+		case HeaderXRealIP:
+			chain = []string{strings.TrimSpace(values[len(values)-1])}
+		case HeaderForwarded:
+			chain = forwardedChain(values)
+		}
+		if ip, ok := r.walk(chain); ok {
+			return ip
+		}
+	}
+	return peer
+}
+
+// walk returns the last address of chain which is not trusted, or the first address when all
+// of them are trusted. It fails on an address which cannot be parsed, because everything before
+// it may be forged.
+func (r *Resolver) walk(chain []string) (string, bool) {
+	client := ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(chain[i])
+		if err != nil {
+			return client, client != ""
+		}
+		client = addr.Unmap().String()
+		if !r.isTrusted(client) {
+			return client, true
+		}
+	}
+	return client, client != ""
+}
+
+func forwardedForChain(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, ip := range strings.Split(value, ",") {
+			chain = append(chain, RemoteIP(strings.TrimSpace(ip)))
+		}
+	}
+	return chain
+}
+
+// forwardedChain returns addresses from "for" parameters of Forwarded header (RFC 7239).
+func forwardedChain(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, node, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				// obfuscated and unknown nodes stay in chain and stop the walk
+				chain = append(chain, RemoteIP(strings.Trim(node, `"`)))
+			}
+		}
+	}
+	return chain
+}
@@ -0,0 +1,74 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRemoteIP(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1:1234":     "192.0.2.1",
+		"192.0.2.1":          "192.0.2.1",
+		"[2001:db8::1]:4711": "2001:db8::1",
+		"[2001:db8::1]":      "2001:db8::1",
+		"2001:db8::1":        "2001:db8::1",
+	}
+
+	for remoteAddr, want := range tests {
+		if got := RemoteIP(remoteAddr); got != want {
+			t.Errorf("wrong ip of %s. got %s want %s", remoteAddr, got, want)
+		}
+	}
+}
+
+func TestResolverClientIP(t *testing.T) {
+	resolver := NewResolver([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}, DefaultHeaders)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{name: "no headers", remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "untrusted peer", remoteAddr: "192.0.2.1:1234", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, want: "192.0.2.1"},
+		{name: "x-forwarded-for", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "x-forwarded-for chain", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "x-forwarded-for only proxies", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "x-forwarded-for garbage", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "nonsense"}, want: "10.0.0.1"},
+		{name: "x-real-ip", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Real-IP": "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "header preference", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Real-IP": "198.51.100.1", "X-Forwarded-For": "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "forwarded", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8::7]:4711", for=10.0.0.2`}, want: "2001:db8::7"},
+		{name: "forwarded obfuscated", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"}, want: "10.0.0.2"},
+		{name: "ipv6 peer", remoteAddr: "[2001:db8:ffff::1]:1234", headers: map[string]string{"X-Forwarded-For": "::ffff:203.0.113.7"}, want: "203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for key, value := range test.headers {
+				request.Header.Set(key, value)
+			}
+
+			if got := resolver.ClientIP(request); got != test.want {
+				t.Errorf("wrong client ip. got %s want %s", got, test.want)
+			}
+		})
+	}
+
+	t.Run("nil resolver", func(t *testing.T) {
+		var nilResolver *Resolver
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+		if got := nilResolver.ClientIP(request); got != "192.0.2.1" {
+			t.Errorf("wrong client ip. got %s want %s", got, "192.0.2.1")
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/ajablonsk1/gload-balancer/internal/clientip"
+)
+
+// ClientIPConfig describes how address of client is found. Strategies, sticky sessions and logs
+// use this address instead of the address of the connection.
+type ClientIPConfig struct {
+	// TrustedProxies are addresses or CIDRs of proxies whose headers with client address are believed.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// Headers are read in given order, by default X-Forwarded-For, X-Real-Ip and Forwarded.
+	Headers []string `json:"headers"`
+}
+
+func (c *ClientIPConfig) applyDefaults() {
+	if len(c.Headers) == 0 {
+		c.Headers = append([]string(nil), clientip.DefaultHeaders...)
+	}
+	for i, header := range c.Headers {
+		c.Headers[i] = http.CanonicalHeaderKey(header)
+	}
+}
+
+func (c *ClientIPConfig) validate(path string, errs *ValidationErrors) {
+	if len(c.TrustedProxies) == 0 {
+		errs.add(path+".trusted_proxies", "there must be at least one trusted proxy")
+	}
+	for i, proxy := range c.TrustedProxies {
+		if _, err := parsePrefix(proxy); err != nil {
+			errs.add(fmt.Sprintf("%s.trusted_proxies[%d]", path, i), "invalid address or CIDR %q", proxy)
+		}
+	}
+	for i, header := range c.Headers {
+		if !isClientIPHeader(header) {
+			errs.add(fmt.Sprintf("%s.headers[%d]", path, i), "unsupported header %q, must be one of %s",
+				header, strings.Join(clientip.DefaultHeaders, ", "))
+		}
+	}
+}
+
+func isClientIPHeader(header string) bool {
+	for _, supported := range clientip.DefaultHeaders {
+		if http.CanonicalHeaderKey(header) == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses CIDR or single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// GetResolver returns resolver of client address, nil config means address of connection is used.
+func (c *ClientIPConfig) GetResolver() (*clientip.Resolver, error) {
+	if c == nil {
+		return nil, nil
+	}
+	trusted := make([]netip.Prefix, len(c.TrustedProxies))
+	for i, proxy := range c.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_proxies[%d]: %w", i, err)
+		}
+		trusted[i] = prefix
+	}
+	headers := c.Headers
+	if len(headers) == 0 {
+		headers = clientip.DefaultHeaders
+	}
+	return clientip.NewResolver(trusted, headers), nil
+}
@@ -1,127 +1,119 @@
 package config
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"net/http/httputil"
-	"net/url"
 	"os"
-	"slices"
-	"go.uber.org/atomic"
-	"time"
-
-	"github.com/ajablonsk1/gload-balancer/internal/model"
 )
 
-type Config map[string]interface{}
+type Config struct {
+	// Address and TLS configure the only listener, when Listeners are not used.
+	Address   string             `json:"address,omitempty"`
+	TLS       *ListenerTLSConfig `json:"tls,omitempty"`
+	Listeners []ListenerConfig   `json:"listeners,omitempty"`
+	// Pools are named pools which routes send requests to.
+	Pools  map[string]PoolConfig `json:"pools,omitempty"`
+	Routes []RouteConfig         `json:"routes,omitempty"`
+	// NoHealthyUpstream is response sent by every pool without healthy servers.
+	NoHealthyUpstream NoUpstreamConfig `json:"no_healthy_upstream"`
+	// ClientIP configures trusted proxies, nil means address of connection is the client address.
+	ClientIP *ClientIPConfig `json:"client_ip,omitempty"`
+	// PoolConfig is the default pool, used by listeners without servers of their own
+	// for requests not matching any route.
+	PoolConfig
+
+	// references are values of config file before expansion by their paths, see interpolateTree.
+	references map[string]string
+}
+
+// GetConfig reads config file, decodes it strictly (unknown keys are rejected) and fills in defaults.
+// Format is detected from file extension. References to environment variables and secret files
+// in string values are expanded before decoding, see interpolateTree.
+// It does not validate the result, call Validate before building anything from it.
+func GetConfig(path string) (*Config, error) {
+	return GetConfigFormat(path, "")
+}
+
+// GetConfigFormat works like GetConfig but decodes file in given format (json, yaml or toml).
+// Empty format means it is detected from file extension.
+func GetConfigFormat(path string, format string) (*Config, error) {
+	if format == "" {
+		detected, err := DetectFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		format = detected
+	}
 
-func GetConfig(path string) (Config, error) {
 	configFile, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var config Config
-	if err := json.Unmarshal(configFile, &config); err != nil {
+	tree, err := parseTree(configFile, format)
+	if err != nil {
 		return nil, err
 	}
 
-	return config, nil
-}
+	var errs ValidationErrors
+	references := make(map[string]string)
+	tree = interpolateTree(tree, "", references, &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
-func (c Config) GetAddress() (string, error) {
-	if address, ok := c["address"].(string); ok {
-		return address, nil
-	} else {
-		return address, errors.New("wrong config or no address attribute provided")
+	var config Config
+	if err := decodeStrict(tree, &config); err != nil {
+		return nil, err
 	}
+	config.references = references
+	config.applyDefaults()
+
+	return &config, nil
 }
 
-func (c Config) GetLoadStrategy() (model.LoadDistributionStrategy, error) {
-	if loadStrategy, ok := c["strategy"].(string); ok {
-		switch loadStrategy {
-		case "round-robin":
-			return &model.RoundRobin{}, nil
-		case "weighted-round-robin":
-			return &model.WeightedRoundRobin{}, nil
-		case "ip-hash":
-			return &model.IPHash{}, nil
-		case "least-connection":
-			return &model.LeastSession{}, nil
-		case "weighted-least-connection":
-			return &model.WeightedLeastSession{}, nil
-		default:
-			return nil, errors.New("wrong strategy type in config file")
-		}
-	} else {
-		return nil, errors.New("wrong config or no strategy attribute provided")
+func (c *Config) applyDefaults() {
+	if c.TLS != nil {
+		c.TLS.applyDefaults()
+	}
+	for i := range c.Listeners {
+		c.Listeners[i].applyDefaults(i)
+	}
+	for name, pool := range c.Pools {
+		pool.applyDefaults()
+		c.Pools[name] = pool
+	}
+	for i := range c.Routes {
+		c.Routes[i].applyDefaults(i)
+	}
+	c.PoolConfig.applyDefaults()
+	c.NoHealthyUpstream.applyDefaults()
+	if c.ClientIP != nil {
+		c.ClientIP.applyDefaults()
 	}
 }
 
-func (c Config) GetServerPool() (*model.ServerPool, error) {
-	if serversJson, ok := c["servers"].([]interface{}); ok {
-		if len(serversJson) < 1 {
-			return nil, errors.New("there must be at least one server provided")
-		}
-
-		servers := make([]*model.Server, 0)
-		for _, server := range serversJson {
-			server, ok := server.(map[string]interface{})
-			if !ok {
-				return nil, fmt.Errorf("error with type assertion. expected map[string]interface{} got %T", server)
-			}
-
-			serverUrl, err := c.getServerUrl(server)
-			if err != nil {
-				return nil, err
-			}
-
-			proxy := httputil.NewSingleHostReverseProxy(serverUrl)
-
-			weight := c.getServerWeight(server)
-
-			servers = append(servers, &model.Server{
-				Url:            serverUrl,
-				Alive:          atomic.NewBool(true),
-				Proxy:          proxy,
-				Weight:         weight,
-				StickySessions: make(map[string]time.Time),
-			})
-		}
-
-		strategy, err := c.GetLoadStrategy()
-		if err != nil {
-			return nil, err
-		}
-
-		if _, ok := strategy.(*model.WeightedRoundRobin); ok {
-			slices.SortFunc(servers, model.SortByWeight)
-		}
-		
-		return &model.ServerPool{
-			Servers: servers,
-		}, nil
-	} else {
-		return nil, errors.New("wrong config or no servers attribute provided")
+func (c *Config) GetAddress() (string, error) {
+	if c.Address == "" {
+		return "", fmt.Errorf("address: missing")
 	}
+	return c.Address, nil
 }
 
-func (c Config) getServerUrl(server map[string]interface{}) (*url.URL, error) {
-	host, ok := server["host"].(string)
-	if !ok {
-		return nil, fmt.Errorf("error with type assertion. expected string under 'host' key")
+// GetListeners returns configured listeners. Config without listeners has one implicit listener
+// built from address and tls.
+func (c *Config) GetListeners() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
 	}
 
-	serverUrl, err := url.Parse("http://" + host)
-	if err != nil {
-		return nil, err
+	listener := ListenerConfig{
+		Name:     DefaultListenerName,
+		Address:  c.Address,
+		Protocol: ProtocolHTTP,
+		TLS:      c.TLS,
 	}
-
-	return serverUrl, nil
-}
-
-func (c Config) getServerWeight(server map[string]interface{}) int {
-	weight, _ := server["weight"].(float64)
-	return int(weight)
+	if c.TLS != nil {
+		listener.Protocol = ProtocolHTTPS
+	}
+	return []ListenerConfig{listener}
 }
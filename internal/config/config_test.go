@@ -1,12 +1,36 @@
 package config_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ajablonsk1/gload-balancer/internal/config"
+	"github.com/ajablonsk1/gload-balancer/internal/handler"
 	"github.com/ajablonsk1/gload-balancer/internal/model"
+	"github.com/ajablonsk1/gload-balancer/pkg/strategy"
 )
 
+func writeConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("error while writing config: %s", err.Error())
+	}
+	return path
+}
+
 func TestGetConfig(t *testing.T) {
 	t.Run("created valid config", func(t *testing.T) {
 		c, err := config.GetConfig("../../config/config.json")
@@ -14,24 +38,268 @@ func TestGetConfig(t *testing.T) {
 			t.Errorf("error while creating config: %s", err.Error())
 		}
 
-		got := c["address"]
+		got := c.Address
 		want := "localhost:8080"
 		if got != want {
 			t.Errorf("wrong address. got %s want %s", got, want)
 		}
 
-		got = c["strategy"]
+		got = c.Strategy.Name
 		want = "round-robin"
 		if got != want {
 			t.Errorf("wrong stategy. got %s want %s", got, want)
 		}
 
-		got = c["servers"].([]interface{})[0].(map[string]interface{})["host"]
+		got = c.Servers[0].Host
 		want = "localhost:1111"
 		if got != want {
 			t.Errorf("wrong first sever host. got %s want %s", got, want)
 		}
 	})
+
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		path := writeConfig(t, "config.json", `{
+			"address": "localhost:8080",
+			"servers": [{"host": "localhost:1111", "wieght": 3}]
+		}`)
+
+		_, err := config.GetConfig(path)
+
+		var errs config.ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("expected validation errors, got %v", err)
+		}
+		if len(errs) != 1 || errs[0].Path != "servers[0].wieght" || errs[0].Message != "unknown field" {
+			t.Errorf("wrong errors. got %v", errs)
+		}
+	})
+
+	t.Run("rejects wrong types", func(t *testing.T) {
+		path := writeConfig(t, "config.json", `{
+			"address": 8080,
+			"servers": [{"host": "localhost:1111", "weight": "3"}]
+		}`)
+
+		_, err := config.GetConfig(path)
+
+		var errs config.ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("expected validation errors, got %v", err)
+		}
+		want := config.ValidationErrors{
+			{Path: "address", Message: "expected string, got number"},
+			{Path: "servers[0].weight", Message: "expected integer, got string"},
+		}
+		if len(errs) != len(want) || errs[0] != want[0] || errs[1] != want[1] {
+			t.Errorf("wrong errors. got %v want %v", errs, want)
+		}
+	})
+
+	t.Run("fills in defaults", func(t *testing.T) {
+		path := writeConfig(t, "config.json", `{
+			"address": "localhost:8080",
+			"servers": [{"host": "localhost:1111"}]
+		}`)
+
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		if c.Strategy.Name != config.DefaultStrategy {
+			t.Errorf("wrong default strategy. got %s want %s", c.Strategy.Name, config.DefaultStrategy)
+		}
+		if c.Servers[0].Weight != config.DefaultWeight {
+			t.Errorf("wrong default weight. got %d want %d", c.Servers[0].Weight, config.DefaultWeight)
+		}
+	})
+}
+
+func TestGetConfigFormats(t *testing.T) {
+	want, err := config.GetConfig("../../config/config.json")
+	if err != nil {
+		t.Fatalf("error while creating config: %s", err.Error())
+	}
+
+	for _, path := range []string{"../../config/config.yaml", "../../config/config.toml"} {
+		t.Run("decodes "+filepath.Ext(path), func(t *testing.T) {
+			got, err := config.GetConfig(path)
+			if err != nil {
+				t.Fatalf("error while creating config: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("wrong config. got %+v want %+v", got, want)
+			}
+		})
+	}
+
+	unknownField := map[string]string{
+		"config.json": `{"address": "localhost:8080", "servers": [{"host": "localhost:1111", "wieght": 3}]}`,
+		"config.yaml": "address: localhost:8080\nservers:\n  - host: localhost:1111\n    wieght: 3\n",
+		"config.toml": "address = \"localhost:8080\"\n[[servers]]\nhost = \"localhost:1111\"\nwieght = 3\n",
+	}
+	for name, content := range unknownField {
+		t.Run("rejects unknown fields in "+filepath.Ext(name), func(t *testing.T) {
+			_, err := config.GetConfig(writeConfig(t, name, content))
+
+			got := fmt.Sprint(err)
+			want := "invalid config: servers[0].wieght: unknown field"
+			if got != want {
+				t.Errorf("wrong error. got %s want %s", got, want)
+			}
+		})
+	}
+
+	wrongType := map[string]string{
+		"config.json": `{"address": "localhost:8080", "servers": [{"host": "localhost:1111", "weight": "3"}]}`,
+		"config.yaml": "address: localhost:8080\nservers:\n  - host: localhost:1111\n    weight: \"3\"\n",
+		"config.toml": "address = \"localhost:8080\"\n[[servers]]\nhost = \"localhost:1111\"\nweight = \"3\"\n",
+	}
+	for name, content := range wrongType {
+		t.Run("rejects wrong types in "+filepath.Ext(name), func(t *testing.T) {
+			_, err := config.GetConfig(writeConfig(t, name, content))
+
+			got := fmt.Sprint(err)
+			want := "invalid config: servers[0].weight: expected integer, got string"
+			if got != want {
+				t.Errorf("wrong error. got %s want %s", got, want)
+			}
+		})
+	}
+
+	t.Run("uses explicit format", func(t *testing.T) {
+		path := writeConfig(t, "balancer.conf", "address: localhost:8080\nservers:\n  - host: localhost:1111\n")
+
+		if _, err := config.GetConfig(path); err == nil {
+			t.Errorf("expected error for undetectable format")
+		}
+
+		c, err := config.GetConfigFormat(path, config.FormatYAML)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if c.Servers[0].Host != "localhost:1111" {
+			t.Errorf("wrong first sever host. got %s want %s", c.Servers[0].Host, "localhost:1111")
+		}
+	})
+
+	t.Run("reports syntax errors with format", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"config.json": `{"address": `,
+			"config.yaml": "address: [",
+			"config.toml": "address = ",
+		} {
+			_, err := config.GetConfig(writeConfig(t, name, content))
+
+			prefix := "parsing " + strings.TrimPrefix(filepath.Ext(name), ".") + " config: "
+			if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+				t.Errorf("wrong error for %s. got %v want prefix %s", name, err, prefix)
+			}
+		}
+	})
+}
+
+func TestGetConfigInterpolation(t *testing.T) {
+	t.Run("expands variables and secret files", func(t *testing.T) {
+		secret := filepath.Join(t.TempDir(), "backend")
+		if err := os.WriteFile(secret, []byte("secret-host:1113\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("LB_PORT", "9090")
+		t.Setenv("LB_STRATEGY", "")
+		path := writeConfig(t, "config.json", `{
+			"address": "localhost:${LB_PORT}",
+			"strategy": "${LB_STRATEGY:-ip-hash}",
+			"servers": [
+				{"host": "${LB_BACKEND:-localhost:1112}"},
+				{"host": "${file:`+secret+`}"},
+				{"host": "$$literal"}
+			],
+			"routes": [{"path_regex": "^/(v[0-9])/(.*)$", "rewrite": "/${1}/api/$2-${LB_PORT}-$${version}", "pool": "default"}]
+		}`)
+
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		got := []string{c.Address, c.Strategy.Name, c.Servers[0].Host, c.Servers[1].Host, c.Servers[2].Host, c.Routes[0].Rewrite}
+		want := []string{"localhost:9090", "ip-hash", "localhost:1112", "secret-host:1113", "$literal", "/${1}/api/$2-9090-${version}"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong expanded values. got %v want %v", got, want)
+		}
+	})
+
+	t.Run("reports missing variables with paths", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: ${LB_MISSING_ADDRESS}
+servers:
+  - host: localhost:1111
+  - host: ${file:/nonexistent/secret}
+`)
+
+		_, err := config.GetConfig(path)
+
+		var errs config.ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("expected validation errors, got %v", err)
+		}
+		if len(errs) != 2 {
+			t.Fatalf("wrong number of errors. got %v", errs)
+		}
+		if errs[0].Error() != `address: environment variable "LB_MISSING_ADDRESS" is not set` {
+			t.Errorf("wrong error. got %s", errs[0].Error())
+		}
+		if errs[1].Path != "servers[1].host" || !strings.HasPrefix(errs[1].Message, `cannot read secret file "/nonexistent/secret"`) {
+			t.Errorf("wrong error. got %s", errs[1].Error())
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid config", func(t *testing.T) {
+		c, _ := config.GetConfig("../../config/config.json")
+
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected validation error: %s", err.Error())
+		}
+	})
+
+	t.Run("reports all problems", func(t *testing.T) {
+		c := &config.Config{
+			PoolConfig: config.PoolConfig{
+				Strategy: &config.StrategyConfig{Name: "random-ish"},
+				Servers: []config.ServerConfig{
+					{Host: "localhost:1111", Weight: 1},
+					{Host: "http://localhost:1111", Weight: 1},
+					{Weight: -1},
+				},
+			},
+		}
+
+		err := c.Validate()
+
+		var errs config.ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Fatalf("expected validation errors, got %v", err)
+		}
+		want := []string{
+			"address: missing",
+			`strategy: unknown strategy "random-ish", must be one of ` + strings.Join(strategy.Names(), ", "),
+			"servers[1].host: duplicate of servers[0].host",
+			"servers[2].host: missing",
+			"servers[2].weight: must not be negative",
+		}
+		if len(errs) != len(want) {
+			t.Fatalf("wrong number of errors. got %v want %v", errs, want)
+		}
+		for i := range want {
+			if errs[i].Error() != want[i] {
+				t.Errorf("wrong error. got %s want %s", errs[i].Error(), want[i])
+			}
+		}
+	})
 }
 
 func TestGetAdress(t *testing.T) {
@@ -74,6 +342,121 @@ func TestGetStrategy(t *testing.T) {
 	})
 }
 
+func TestStrategyConfig(t *testing.T) {
+	t.Run("accepts object with name", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+strategy:
+  name: ip-hash
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %s", err)
+		}
+
+		strategy, err := c.GetLoadStrategy()
+		if err != nil {
+			t.Fatalf("error getting load strategy: %s", err)
+		}
+		if _, ok := strategy.(*model.IPHash); !ok {
+			t.Errorf("wrong strategy. got %T want %T", strategy, &model.IPHash{})
+		}
+	})
+
+	t.Run("rejects malformed strategy", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+strategy:
+  name: ip-hash
+  algorithm: crc32
+servers:
+  - host: localhost:1111
+`)
+		_, err := config.GetConfig(path)
+
+		want := `strategy: unknown field "algorithm", expected name and params`
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("wrong error. got %v want %s", err, want)
+		}
+	})
+
+	t.Run("passes params to strategy", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+strategy:
+  name: consistent-hash
+  params:
+    key: header:X-Tenant
+    load_factor: 1.5
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		strategy, err := c.GetLoadStrategy()
+		if err != nil {
+			t.Fatalf("error getting load strategy: %s", err)
+		}
+		if ring, ok := strategy.(*model.ConsistentHash); !ok || ring.LoadFactor != 1.5 {
+			t.Errorf("wrong strategy. got %#v", strategy)
+		}
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+strategy:
+  name: consistent-hash
+  params:
+    load_factor: 0.5
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: strategy: load_factor must be at least 1, or 0 to disable bounded loads"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+
+	t.Run("rejects unknown params", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+strategy:
+  name: round-robin
+  params:
+    step: 2
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := `invalid config: strategy: params: json: unknown field "step"`
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+}
+
 func TestGetServerPool(t *testing.T) {
 	t.Run("get server pool from config", func(t *testing.T) {
 		c, err := config.GetConfig("../../config/config.json")
@@ -93,3 +476,688 @@ func TestGetServerPool(t *testing.T) {
 		}
 	})
 }
+
+func TestGetServerPoolTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	proxyThrough := func(t *testing.T, server config.ServerConfig) *httptest.ResponseRecorder {
+		t.Helper()
+		c := &config.Config{
+			Address:    "localhost:8080",
+			PoolConfig: config.PoolConfig{Strategy: &config.StrategyConfig{Name: "round-robin"}, Servers: []config.ServerConfig{server}},
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %s", err)
+		}
+		serverPool, err := c.GetServerPool()
+		if err != nil {
+			t.Fatalf("error getting server pool: %s", err)
+		}
+		response := httptest.NewRecorder()
+		serverPool.Servers[0].Proxy.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+		return response
+	}
+
+	t.Run("verifies server with CA bundle", func(t *testing.T) {
+		host := strings.TrimPrefix(backend.URL, "https://")
+		response := proxyThrough(t, config.ServerConfig{
+			Host:   host,
+			Scheme: "https",
+			Weight: 1,
+			TLS:    &config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"},
+		})
+
+		if response.Code != http.StatusOK || response.Body.String() != "secure" {
+			t.Errorf("wrong response. got %d %s", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("rejects unknown certificate", func(t *testing.T) {
+		response := proxyThrough(t, config.ServerConfig{Host: backend.URL, Weight: 1})
+
+		if response.Code != http.StatusBadGateway {
+			t.Errorf("wrong response code. got %d want %d", response.Code, http.StatusBadGateway)
+		}
+	})
+
+	t.Run("skips verification", func(t *testing.T) {
+		response := proxyThrough(t, config.ServerConfig{
+			Host:   backend.URL,
+			Weight: 1,
+			TLS:    &config.UpstreamTLSConfig{InsecureSkipVerify: true},
+		})
+
+		if response.Code != http.StatusOK {
+			t.Errorf("wrong response code. got %d want %d", response.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("validates scheme and tls options", func(t *testing.T) {
+		c := &config.Config{
+			Address: "localhost:8080",
+			PoolConfig: config.PoolConfig{
+				Strategy: &config.StrategyConfig{Name: "round-robin"},
+				Servers: []config.ServerConfig{
+					{Host: "https://localhost:1111", Scheme: "http", Weight: 1},
+					{Host: "localhost:1112", Scheme: "ftp", Weight: 1},
+					{Host: "localhost:1113", Scheme: "http", Weight: 1, TLS: &config.UpstreamTLSConfig{CertFile: "client.pem"}},
+				},
+			},
+		}
+
+		err := c.Validate()
+
+		want := "invalid config: " +
+			`servers[0].scheme: conflicts with scheme "https" of host; ` +
+			`servers[1].scheme: unsupported scheme "ftp", must be http or https; ` +
+			"servers[2].tls: requires https scheme; " +
+			"servers[2].tls: cert_file and key_file must be provided together"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error. got %v want %s", err, want)
+		}
+	})
+}
+
+func TestListenerTLS(t *testing.T) {
+	t.Run("validates tls settings", func(t *testing.T) {
+		path := writeConfig(t, "config.json", `{
+			"address": "localhost:8443",
+			"tls": {
+				"certificates": [{"cert_file": "server.crt"}],
+				"min_version": "1.4",
+				"cipher_suites": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"],
+				"client_auth": "require-and-verify"
+			},
+			"servers": [{"host": "localhost:1111"}]
+		}`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			"tls.certificates[0].key_file: missing; " +
+			`tls.min_version: unknown TLS version "1.4", must be one of 1.0, 1.1, 1.2, 1.3; ` +
+			`tls.cipher_suites[1]: unknown or insecure cipher suite "TLS_RSA_WITH_RC4_128_SHA"; ` +
+			"tls.client_ca_file: required to verify client certificates"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error. got %v want %s", err, want)
+		}
+	})
+
+	t.Run("builds tls config", func(t *testing.T) {
+		backend := httptest.NewTLSServer(http.NotFoundHandler())
+		defer backend.Close()
+		dir := t.TempDir()
+		certificate := backend.TLS.Certificates[0]
+		certFile := filepath.Join(dir, "server.crt")
+		keyFile := filepath.Join(dir, "server.key")
+		key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		listenerTLS := &config.ListenerTLSConfig{
+			Certificates: []config.CertificateConfig{{CertFile: certFile, KeyFile: keyFile}},
+			MinVersion:   "1.3",
+			CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			ClientAuth:   "verify-if-given",
+			ClientCAFile: certFile,
+		}
+		tlsConfig, store, err := listenerTLS.GetTLSConfig()
+		if err != nil {
+			t.Fatalf("error building tls config: %s", err)
+		}
+
+		if tlsConfig.MinVersion != tls.VersionTLS13 {
+			t.Errorf("wrong min version. got %x want %x", tlsConfig.MinVersion, tls.VersionTLS13)
+		}
+		if len(tlsConfig.CipherSuites) != 1 || tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+			t.Errorf("wrong cipher suites. got %v", tlsConfig.CipherSuites)
+		}
+		if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven || tlsConfig.ClientCAs == nil {
+			t.Errorf("client certificate verification not configured")
+		}
+		if got, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); got == nil {
+			t.Errorf("no certificate returned by store")
+		}
+	})
+}
+
+func TestListenersConfig(t *testing.T) {
+	t.Run("uses implicit listener without listeners", func(t *testing.T) {
+		c, _ := config.GetConfig("../../config/config.json")
+
+		listeners := c.GetListeners()
+
+		if len(listeners) != 1 || listeners[0].Address != "localhost:8080" || listeners[0].Protocol != config.ProtocolHTTP {
+			t.Errorf("wrong implicit listener. got %+v", listeners)
+		}
+	})
+
+	t.Run("validates listeners", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+listeners:
+  - name: web
+    address: :8080
+    protocol: redirect
+    redirect_to: api
+  - name: api
+    address: :8080
+    strategy: ip-hash
+  - name: web
+    address: :9090
+    protocol: https
+  - address: :9091
+    protocol: ftp
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			"address: cannot be used together with listeners; " +
+			`listeners[0].redirect_to: listener "api" is not https listener; ` +
+			"listeners[1].address: duplicate of listeners[0].address; " +
+			"listeners[1].strategy: requires servers, listener without servers uses routes and the default pool; " +
+			"listeners[2].name: duplicate of listeners[0].name; " +
+			"listeners[2].tls: missing, required by https protocol; " +
+			`listeners[3].protocol: unknown protocol "ftp", must be one of http, https, redirect; ` +
+			"servers: there must be at least one server provided"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+
+	t.Run("does not require default pool when every listener has servers", func(t *testing.T) {
+		path := writeConfig(t, "config.json", `{
+			"listeners": [{"address": ":8080", "servers": [{"host": "localhost:1111"}]}]
+		}`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		if err := c.Validate(); err != nil {
+			t.Errorf("unexpected validation error: %s", err)
+		}
+		if c.Listeners[0].Name != "listener-0" || c.Listeners[0].Strategy.Name != config.DefaultStrategy {
+			t.Errorf("wrong listener defaults. got %+v", c.Listeners[0])
+		}
+	})
+
+	t.Run("validates proxy protocol", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+listeners:
+  - address: :8080
+    proxy_protocol: {}
+  - address: :8081
+    proxy_protocol:
+      trusted_sources: [10.0.0.0/8, lb.local]
+      header_timeout: -1s
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if got := c.Listeners[0].ProxyProtocol.HeaderTimeout; got != config.Duration(config.DefaultProxyProtocolHeaderTimeout) {
+			t.Errorf("wrong default header timeout. got %v", time.Duration(got))
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			"listeners[0].proxy_protocol.trusted_sources: missing, every client could pretend to have any address; " +
+			`listeners[1].proxy_protocol.trusted_sources[1]: invalid address or CIDR "lb.local"; ` +
+			"listeners[1].proxy_protocol.header_timeout: must not be negative"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+}
+
+func TestRoutesConfig(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+pools:
+  default:
+    servers:
+      - host: localhost:1111
+  api:
+    servers:
+      - host: localhost:2222
+routes:
+  - name: api
+    path_prefix: api
+    path_regex: "("
+    pool: api
+    strip_prefix: true
+    rewrite: /v2
+  - name: api
+    host: a.*.example.com
+    methods: [get, "P O"]
+    headers:
+      "X Tenant": acme
+    pool: static
+  - pool: default
+`)
+	c, err := config.GetConfig(path)
+	if err != nil {
+		t.Fatalf("error while creating config: %s", err.Error())
+	}
+	if c.Routes[1].Methods[0] != http.MethodGet || c.Routes[2].Name != "route-2" {
+		t.Errorf("wrong route defaults. got %+v", c.Routes)
+	}
+
+	err = c.Validate()
+
+	want := "invalid config: " +
+		"pools.default: name is reserved for the pool of top-level servers; " +
+		"routes[0]: path_prefix and path_regex cannot be used together; " +
+		"routes[0].path_prefix: must start with /; " +
+		"routes[0].path_regex: invalid: error parsing regexp: missing closing ): `(`; " +
+		"routes[0].rewrite: cannot be used together with strip_prefix; " +
+		"routes[1].name: duplicate of routes[0].name; " +
+		"routes[1].host: wildcard is allowed only as the first label, e.g. *.example.com; " +
+		`routes[1].methods[1]: invalid method "P O"; ` +
+		`routes[1].headers: invalid header name "X Tenant"; ` +
+		`routes[1].pool: unknown pool "static"; ` +
+		"routes[2].pool: default pool has no servers"
+	if fmt.Sprint(err) != want {
+		t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+	}
+}
+
+func TestNoHealthyUpstreamConfig(t *testing.T) {
+	t.Run("builds response", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+no_healthy_upstream:
+  status_code: 502
+  retry_after: 30s
+  body: "pool {{.Pool}} is down"
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %s", err)
+		}
+
+		response, err := c.NoHealthyUpstream.GetResponse()
+		if err != nil {
+			t.Fatalf("error while building response: %s", err)
+		}
+		if response.StatusCode != http.StatusBadGateway || response.RetryAfter != 30*time.Second {
+			t.Errorf("wrong response. got %+v", response)
+		}
+		if response.ContentType != config.DefaultNoUpstreamContentType {
+			t.Errorf("wrong content type. got %s want %s", response.ContentType, config.DefaultNoUpstreamContentType)
+		}
+	})
+
+	t.Run("rejects invalid duration", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+no_healthy_upstream:
+  retry_after: soon
+servers:
+  - host: localhost:1111
+`)
+		_, err := config.GetConfig(path)
+
+		want := `no_healthy_upstream.retry_after: invalid duration "soon"`
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("wrong error. got %v want %s", err, want)
+		}
+	})
+
+	t.Run("validates response", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+no_healthy_upstream:
+  status_code: 200
+  retry_after: -1s
+  body: "{{.Pool"
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			"no_healthy_upstream.status_code: must be between 400 and 599; " +
+			"no_healthy_upstream.retry_after: must not be negative; " +
+			"no_healthy_upstream.body: invalid template: template: body:1: unclosed action"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+}
+
+func TestStickyConfig(t *testing.T) {
+	t.Run("builds session policy", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+pools:
+  api:
+    sticky:
+      idle_ttl: 1m
+      absolute_ttl: 1h
+      max_sessions_per_server: 100
+      on_unhealthy: fail
+    servers:
+      - host: localhost:2222
+  static:
+    sticky:
+      enabled: false
+    servers:
+      - host: localhost:3333
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %s", err)
+		}
+
+		api := c.Pools["api"]
+		serverPool, err := api.GetServerPool()
+		if err != nil {
+			t.Fatalf("error while creating server pool: %s", err)
+		}
+		want := model.SessionPolicy{IdleTTL: time.Minute, AbsoluteTTL: time.Hour, MaxPerServer: 100, FailOnUnhealthy: true}
+		if got := serverPool.Sessions.Policy(); got != want {
+			t.Errorf("wrong session policy. got %+v want %+v", got, want)
+		}
+
+		static := c.Pools["static"]
+		serverPool, _ = static.GetServerPool()
+		if serverPool.Sessions != nil {
+			t.Errorf("expected sticky sessions to be disabled")
+		}
+
+		serverPool, _ = c.GetServerPool()
+		if got := serverPool.Sessions.Policy(); got != model.DefaultSessionPolicy {
+			t.Errorf("wrong default session policy. got %+v want %+v", got, model.DefaultSessionPolicy)
+		}
+	})
+
+	t.Run("builds cookie affinity", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+sticky:
+  mode: cookie
+  cookie:
+    name: lb
+    domain: example.com
+    secure: true
+    max_age: 1h
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %s", err)
+		}
+		serverPool, _ := c.GetServerPool()
+		if serverPool.Sessions != nil {
+			t.Errorf("cookie mode should not pin clients by address")
+		}
+
+		affinity, ok := c.GetAffinity(serverPool, nil).(*handler.CookieAffinity)
+		if !ok {
+			t.Fatalf("wrong affinity. got %T want %T", c.GetAffinity(serverPool, nil), &handler.CookieAffinity{})
+		}
+		want := http.Cookie{Name: "lb", Path: "/", Domain: "example.com", MaxAge: 3600, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+		if !reflect.DeepEqual(affinity.Cookie, want) {
+			t.Errorf("wrong cookie. got %+v want %+v", affinity.Cookie, want)
+		}
+	})
+
+	t.Run("keeps learned sessions on reload", func(t *testing.T) {
+		pool := config.PoolConfig{
+			Strategy: &config.StrategyConfig{Name: config.DefaultStrategy},
+			Sticky:   &config.StickyConfig{Mode: config.StickyAppCookie, Name: "JSESSIONID"},
+			Servers:  []config.ServerConfig{{Host: "localhost:1111"}, {Host: "localhost:1112"}},
+		}
+		serverPool, err := pool.GetServerPool()
+		if err != nil {
+			t.Fatalf("error while creating server pool: %s", err)
+		}
+		affinity := pool.GetAffinity(serverPool, nil).(*handler.LearnedAffinity)
+		affinity.Sessions().Add("abc", serverPool.Servers[1])
+
+		next := config.PoolConfig{
+			Strategy: &config.StrategyConfig{Name: config.DefaultStrategy},
+			Sticky:   &config.StickyConfig{Mode: config.StickyAppCookie, Name: "JSESSIONID"},
+			Servers:  []config.ServerConfig{{Host: "localhost:1112"}},
+		}
+		nextPool, err := next.UpdateServerPool(serverPool, &pool)
+		if err != nil {
+			t.Fatalf("error while updating server pool: %s", err)
+		}
+		nextAffinity := next.GetAffinity(nextPool, affinity).(*handler.LearnedAffinity)
+
+		if got := nextAffinity.Sessions().Get("abc"); got != serverPool.Servers[1] {
+			t.Errorf("learned session was not kept. got %v want %v", got, serverPool.Servers[1])
+		}
+		if nextPool.Sessions != nil {
+			t.Errorf("app-cookie mode should not pin clients by address")
+		}
+	})
+
+	t.Run("validates cookie", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+pools:
+  api:
+    sticky:
+      mode: cookie
+      cookie:
+        name: "a b"
+        path: api
+        same_site: none
+        max_age: -1s
+    servers:
+      - host: localhost:2222
+  static:
+    sticky:
+      mode: tenant
+    servers:
+      - host: localhost:3333
+  tenants:
+    sticky:
+      mode: header
+    servers:
+      - host: localhost:4444
+  users:
+    sticky:
+      mode: app-cookie
+      name: "JSESSION ID"
+    servers:
+      - host: localhost:5555
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			`pools.api.sticky.cookie.name: invalid cookie name "a b"; ` +
+			"pools.api.sticky.cookie.path: must start with /; " +
+			"pools.api.sticky.cookie.same_site: none requires secure cookie; " +
+			"pools.api.sticky.cookie.max_age: must not be negative; " +
+			`pools.static.sticky.mode: unknown mode "tenant", must be address, cookie, app-cookie or header; ` +
+			"pools.tenants.sticky.name: missing, header mode requires name of the header; " +
+			`pools.users.sticky.name: invalid cookie name "JSESSION ID"`
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+
+	t.Run("does not pin by address with strategies without sessions", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+pools:
+  api:
+    strategy: p2c
+    servers:
+      - host: localhost:2222
+  static:
+    strategy: maglev
+    sticky:
+      idle_ttl: 1m
+    servers:
+      - host: localhost:3333
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		api := c.Pools["api"]
+		if api.Sticky != nil {
+			t.Errorf("expected no default sticky policy. got %+v", api.Sticky)
+		}
+		serverPool, _ := api.GetServerPool()
+		if serverPool.Sessions != nil {
+			t.Errorf("expected no session store")
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			"pools.static.sticky: strategy maglev does not pin clients by address, use cookie, app-cookie or header mode or disable sticky sessions"
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+
+	t.Run("validates policy", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+sticky:
+  idle_ttl: -1s
+  absolute_ttl: -1m
+  max_sessions_per_server: -5
+  on_unhealthy: retry
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			"sticky.idle_ttl: must not be negative; " +
+			"sticky.absolute_ttl: must not be negative; " +
+			"sticky.max_sessions_per_server: must not be negative; " +
+			`sticky.on_unhealthy: unknown value "retry", must be failover or fail`
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+}
+
+func TestClientIPConfig(t *testing.T) {
+	t.Run("builds resolver", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+client_ip:
+  trusted_proxies: [10.0.0.0/8, 192.168.1.1]
+  headers: [x-real-ip]
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %s", err)
+		}
+		resolver, err := c.ClientIP.GetResolver()
+		if err != nil {
+			t.Fatalf("error while creating resolver: %s", err)
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = "192.168.1.1:1234"
+		request.Header.Set("X-Real-IP", "203.0.113.7")
+		request.Header.Set("X-Forwarded-For", "198.51.100.1")
+		if got := resolver.ClientIP(request); got != "203.0.113.7" {
+			t.Errorf("wrong client ip. got %s want %s", got, "203.0.113.7")
+		}
+	})
+
+	t.Run("validates trusted proxies and headers", func(t *testing.T) {
+		path := writeConfig(t, "config.yaml", `
+address: localhost:8080
+client_ip:
+  trusted_proxies: [10.0.0.0/33, proxy.local]
+  headers: [X-Forwarded-For, X-Client-IP]
+servers:
+  - host: localhost:1111
+`)
+		c, err := config.GetConfig(path)
+		if err != nil {
+			t.Fatalf("error while creating config: %s", err.Error())
+		}
+
+		err = c.Validate()
+
+		want := "invalid config: " +
+			`client_ip.trusted_proxies[0]: invalid address or CIDR "10.0.0.0/33"; ` +
+			`client_ip.trusted_proxies[1]: invalid address or CIDR "proxy.local"; ` +
+			`client_ip.headers[1]: unsupported header "X-Client-Ip", must be one of X-Forwarded-For, X-Real-Ip, Forwarded`
+		if fmt.Sprint(err) != want {
+			t.Errorf("wrong error.\ngot  %v\nwant %s", err, want)
+		}
+	})
+}
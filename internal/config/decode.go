@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	nodeCheckerType = reflect.TypeOf((*nodeChecker)(nil)).Elem()
+)
+
+// nodeChecker is implemented by types with custom decoding, which check tree nodes themselves,
+// so that their errors are reported with paths like all the others.
+type nodeChecker interface {
+	checkNode(node interface{}) error
+}
+
+// decodeStrict decodes generic tree (as produced by json.Unmarshal into interface{}) into v.
+// Before decoding, the tree is checked against the type of v and all unknown keys and type
+// mismatches are reported together as ValidationErrors.
+func decodeStrict(tree interface{}, v interface{}) error {
+	var errs ValidationErrors
+	checkTree(tree, reflect.TypeOf(v).Elem(), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func checkTree(node interface{}, t reflect.Type, path string, errs *ValidationErrors) {
+	if node == nil {
+		return
+	}
+	if reflect.PointerTo(t).Implements(nodeCheckerType) {
+		if err := reflect.New(t).Interface().(nodeChecker).checkNode(node); err != nil {
+			errs.add(path, "%s", err)
+		}
+		return
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		// types with custom decoding validate themselves when decoded
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		checkTree(node, t.Elem(), path, errs)
+	case reflect.Interface:
+		return
+	case reflect.Struct:
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			errs.add(path, "expected object, got %s", describe(node))
+			return
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(object) {
+			field, ok := fields[key]
+			if !ok {
+				errs.add(join(path, key), "unknown field")
+				continue
+			}
+			checkTree(object[key], field.Type, join(path, key), errs)
+		}
+	case reflect.Map:
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			errs.add(path, "expected object, got %s", describe(node))
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			checkTree(object[key], t.Elem(), join(path, key), errs)
+		}
+	case reflect.Slice:
+		array, ok := node.([]interface{})
+		if !ok {
+			errs.add(path, "expected array, got %s", describe(node))
+			return
+		}
+		for i, value := range array {
+			checkTree(value, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.String:
+		if _, ok := node.(string); !ok {
+			errs.add(path, "expected string, got %s", describe(node))
+		}
+	case reflect.Bool:
+		if _, ok := node.(bool); !ok {
+			errs.add(path, "expected boolean, got %s", describe(node))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := node.(float64)
+		if !ok || number != float64(int64(number)) {
+			errs.add(path, "expected integer, got %s", describe(node))
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := node.(float64); !ok {
+			errs.add(path, "expected number, got %s", describe(node))
+		}
+	}
+}
+
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			// fields of embedded structs are promoted, same as in encoding/json
+			for name, embedded := range jsonFields(field.Type) {
+				fields[name] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func sortedKeys[V any](object map[string]V) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func describe(node interface{}) string {
+	switch node.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
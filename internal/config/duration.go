@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is time.Duration written in config as a string, e.g. "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected duration string, e.g. \"10s\"")
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+func (d *Duration) checkNode(node interface{}) error {
+	s, ok := node.(string)
+	if !ok {
+		return fmt.Errorf("expected duration string, e.g. \"10s\", got %s", describe(node))
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	return nil
+}
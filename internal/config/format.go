@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+var formats = map[string]func(data []byte, tree *interface{}) error{
+	FormatJSON: func(data []byte, tree *interface{}) error {
+		return json.Unmarshal(data, tree)
+	},
+	FormatYAML: func(data []byte, tree *interface{}) error {
+		return yaml.Unmarshal(data, tree)
+	},
+	FormatTOML: func(data []byte, tree *interface{}) error {
+		table := make(map[string]interface{})
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return err
+		}
+		*tree = table
+		return nil
+	},
+}
+
+// DetectFormat guesses config format from file extension.
+func DetectFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("cannot detect config format of %s, use one of .json, .yaml, .yml, .toml extensions or set format explicitly", path)
+	}
+}
+
+// parseTree parses config file in given format into generic tree. The tree is normalized through
+// JSON so that every format produces the same node types and therefore the same decoding errors.
+func parseTree(data []byte, format string) (interface{}, error) {
+	unmarshal, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+
+	var tree interface{}
+	if err := unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("parsing %s config: %w", format, err)
+	}
+
+	normalized, err := json.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s config: %w", format, err)
+	}
+	tree = nil
+	if err := json.Unmarshal(normalized, &tree); err != nil {
+		return nil, fmt.Errorf("parsing %s config: %w", format, err)
+	}
+	return tree, nil
+}
+
+// Redacted replaces values of secret fields, which were not expanded from references.
+const Redacted = "<redacted>"
+
+// secretFields are keys of fields holding secrets.
+var secretFields = map[string]bool{
+	"secret": true,
+}
+
+// Marshal encodes config in given format (json, yaml or toml). Values expanded from references
+// are printed as references and values of secret fields as Redacted, so the output can be shown
+// in logs.
+func Marshal(c *Config, format string) ([]byte, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	// going through generic tree keeps key names from json tags
+	var tree map[string]interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	unexpand(tree, "", c.references)
+
+	switch format {
+	case FormatJSON:
+		var buffer bytes.Buffer
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(tree); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case FormatYAML, FormatTOML:
+		restoreIntegers(tree)
+		if format == FormatYAML {
+			return yaml.Marshal(tree)
+		}
+		var buffer bytes.Buffer
+		if err := toml.NewEncoder(&buffer).Encode(tree); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+}
+
+// unexpand puts back references into string values of the tree and redacts secret fields.
+// Paths are built the same way as by interpolateTree.
+func unexpand(node interface{}, path string, references map[string]string) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			childPath := join(path, key)
+			if _, ok := child.(string); !ok {
+				unexpand(child, childPath, references)
+			} else if reference, ok := references[childPath]; ok {
+				value[key] = reference
+			} else if secretFields[key] && child != "" {
+				value[key] = Redacted
+			}
+		}
+	case []interface{}:
+		for i, child := range value {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			if _, ok := child.(string); !ok {
+				unexpand(child, childPath, references)
+			} else if reference, ok := references[childPath]; ok {
+				value[i] = reference
+			}
+		}
+	}
+}
+
+// restoreIntegers converts whole numbers, which JSON decodes as float64, back to integers,
+// so they are not printed as floats in formats distinguishing between the two.
+func restoreIntegers(node interface{}) interface{} {
+	switch value := node.(type) {
+	case float64:
+		if value == float64(int64(value)) {
+			return int64(value)
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = restoreIntegers(value[key])
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = restoreIntegers(value[i])
+		}
+	}
+	return node
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const secretFilePrefix = "file:"
+
+// interpolateTree expands references in every string value of the tree:
+//
+//	${VAR}            value of environment variable VAR, which must be set
+//	${VAR:-default}   value of VAR or default when VAR is unset or empty
+//	${file:/path}     content of file at path without trailing newline, useful for mounted secrets
+//	$$                literal $
+//
+// Other ${...}, e.g. ${1} referencing capture group in rewrite of a route, are left as they are,
+// as is $ not followed by {. Names of capture groups look like names of variables, so
+// ${name} needs to be written as $${name}. Keys are never expanded. Values before expansion are recorded in references by their paths.
+// All failures are reported with paths of values containing them.
+func interpolateTree(node interface{}, path string, references map[string]string, errs *ValidationErrors) interface{} {
+	switch value := node.(type) {
+	case string:
+		expanded, err := interpolate(value)
+		if err != nil {
+			errs.add(path, "%s", err)
+			return value
+		}
+		if expanded != value {
+			references[path] = value
+		}
+		return expanded
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			value[key] = interpolateTree(value[key], join(path, key), references, errs)
+		}
+		return value
+	case []interface{}:
+		for i := range value {
+			value[i] = interpolateTree(value[i], fmt.Sprintf("%s[%d]", path, i), references, errs)
+		}
+		return value
+	default:
+		return node
+	}
+}
+
+func interpolate(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var result strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			result.WriteString(s)
+			return result.String(), nil
+		}
+		result.WriteString(s[:i])
+
+		switch s[i+1] {
+		case '$':
+			result.WriteByte('$')
+			s = s[i+2:]
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed reference %q", s[i:])
+			}
+			reference := s[i+2 : i+end]
+			if !isReference(reference) {
+				result.WriteString(s[i : i+end+1])
+				s = s[i+end+1:]
+				continue
+			}
+			value, err := resolve(reference)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			s = s[i+end+1:]
+		default:
+			result.WriteByte('$')
+			s = s[i+1:]
+		}
+	}
+}
+
+// isReference reports whether content of ${...} refers to secret file or environment variable.
+// Empty name is a reference, so that it is reported.
+func isReference(reference string) bool {
+	if strings.HasPrefix(reference, secretFilePrefix) {
+		return true
+	}
+	name, _, _ := strings.Cut(reference, ":-")
+	for i, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func resolve(reference string) (string, error) {
+	if path, ok := strings.CutPrefix(reference, secretFilePrefix); ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("cannot read secret file %q: %w", path, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	name, fallback, hasFallback := strings.Cut(reference, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty reference ${%s}", reference)
+	}
+	value, ok := os.LookupEnv(name)
+	if hasFallback && value == "" {
+		return fallback, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %q is not set", name)
+	}
+	return value, nil
+}
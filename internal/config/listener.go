@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	// ProtocolRedirect listener answers every request with permanent redirect to https listener.
+	ProtocolRedirect = "redirect"
+
+	DefaultListenerName = "default"
+
+	DefaultProxyProtocolHeaderTimeout = 5 * time.Second
+)
+
+type ListenerConfig struct {
+	Name     string             `json:"name"`
+	Address  string             `json:"address"`
+	Protocol string             `json:"protocol"`
+	TLS      *ListenerTLSConfig `json:"tls,omitempty"`
+	// RedirectTo is the name of https listener which redirect listener sends clients to.
+	RedirectTo string `json:"redirect_to,omitempty"`
+	// ProxyProtocol enables decoding of PROXY protocol header sent by L4 load balancer.
+	ProxyProtocol *ProxyProtocolConfig `json:"proxy_protocol,omitempty"`
+	// PoolConfig is optional, listener without servers uses routes and the default pool.
+	PoolConfig
+}
+
+// ProxyProtocolConfig describes which sources send PROXY protocol header. Connections from
+// trusted sources without valid header are closed, other sources connect without header.
+type ProxyProtocolConfig struct {
+	// TrustedSources are addresses or CIDRs of load balancers, at least one is required.
+	TrustedSources []string `json:"trusted_sources,omitempty"`
+	HeaderTimeout  Duration `json:"header_timeout"`
+}
+
+func (p *ProxyProtocolConfig) validate(path string, errs *ValidationErrors) {
+	if len(p.TrustedSources) == 0 {
+		errs.add(path+".trusted_sources", "missing, every client could pretend to have any address")
+	}
+	for i, source := range p.TrustedSources {
+		if _, err := parsePrefix(source); err != nil {
+			errs.add(fmt.Sprintf("%s.trusted_sources[%d]", path, i), "invalid address or CIDR %q", source)
+		}
+	}
+	if p.HeaderTimeout < 0 {
+		errs.add(path+".header_timeout", "must not be negative")
+	}
+}
+
+func (p *ProxyProtocolConfig) GetTrustedSources() ([]netip.Prefix, error) {
+	trusted := make([]netip.Prefix, len(p.TrustedSources))
+	for i, source := range p.TrustedSources {
+		prefix, err := parsePrefix(source)
+		if err != nil {
+			return nil, fmt.Errorf("trusted_sources[%d]: %w", i, err)
+		}
+		trusted[i] = prefix
+	}
+	return trusted, nil
+}
+
+func (l *ListenerConfig) applyDefaults(i int) {
+	if l.Name == "" {
+		l.Name = fmt.Sprintf("listener-%d", i)
+	}
+	if l.Protocol == "" {
+		l.Protocol = ProtocolHTTP
+		if l.TLS != nil {
+			l.Protocol = ProtocolHTTPS
+		}
+	}
+	if l.TLS != nil {
+		l.TLS.applyDefaults()
+	}
+	if l.ProxyProtocol != nil && l.ProxyProtocol.HeaderTimeout == 0 {
+		l.ProxyProtocol.HeaderTimeout = Duration(DefaultProxyProtocolHeaderTimeout)
+	}
+	if len(l.Servers) > 0 {
+		l.PoolConfig.applyDefaults()
+	}
+}
+
+func (c *Config) validateListeners(errs *ValidationErrors) {
+	names := make(map[string]int)
+	addresses := make(map[string]int)
+	for i, listener := range c.Listeners {
+		if _, ok := names[listener.Name]; !ok {
+			names[listener.Name] = i
+		}
+	}
+
+	for i, listener := range c.Listeners {
+		path := fmt.Sprintf("listeners[%d]", i)
+
+		if j := names[listener.Name]; j != i {
+			errs.add(path+".name", "duplicate of listeners[%d].name", j)
+		}
+
+		validateAddress(path+".address", listener.Address, errs)
+		if j, ok := addresses[listener.Address]; ok {
+			errs.add(path+".address", "duplicate of listeners[%d].address", j)
+		} else {
+			addresses[listener.Address] = i
+		}
+
+		if listener.ProxyProtocol != nil {
+			listener.ProxyProtocol.validate(path+".proxy_protocol", errs)
+		}
+
+		switch listener.Protocol {
+		case ProtocolHTTP, ProtocolRedirect:
+			if listener.TLS != nil {
+				errs.add(path+".tls", "requires https protocol")
+			}
+		case ProtocolHTTPS:
+			if listener.TLS == nil {
+				errs.add(path+".tls", "missing, required by https protocol")
+			} else {
+				listener.TLS.validate(path+".tls", errs)
+			}
+		default:
+			errs.add(path+".protocol", "unknown protocol %q, must be one of http, https, redirect", listener.Protocol)
+		}
+
+		if listener.Protocol == ProtocolRedirect {
+			if len(listener.Servers) > 0 || listener.Strategy != nil {
+				errs.add(path, "redirect listener cannot have servers or strategy")
+			}
+			if j, ok := names[listener.RedirectTo]; !ok {
+				errs.add(path+".redirect_to", "unknown listener %q", listener.RedirectTo)
+			} else if c.Listeners[j].Protocol != ProtocolHTTPS {
+				errs.add(path+".redirect_to", "listener %q is not https listener", listener.RedirectTo)
+			} else if _, _, err := net.SplitHostPort(c.Listeners[j].Address); err != nil {
+				errs.add(path+".redirect_to", "cannot get port of listener %q: %s", listener.RedirectTo, err)
+			}
+			continue
+		}
+
+		if listener.RedirectTo != "" {
+			errs.add(path+".redirect_to", "requires redirect protocol")
+		}
+		if len(listener.Servers) > 0 {
+			listener.PoolConfig.validate(path, errs)
+		} else if listener.Strategy != nil {
+			errs.add(path+".strategy", "requires servers, listener without servers uses routes and the default pool")
+		}
+	}
+}
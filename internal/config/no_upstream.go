@@ -0,0 +1,61 @@
+package config
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ajablonsk1/gload-balancer/internal/handler"
+)
+
+const (
+	DefaultNoUpstreamContentType = "text/plain; charset=utf-8"
+	DefaultNoUpstreamBody        = "no healthy upstream\n"
+)
+
+// NoUpstreamConfig configures response sent when there is no healthy server in a pool.
+type NoUpstreamConfig struct {
+	StatusCode  int      `json:"status_code"`
+	RetryAfter  Duration `json:"retry_after,omitempty"`
+	ContentType string   `json:"content_type"`
+	// Body is a template executed with fields .Pool, .Method, .Host and .Path. HTML bodies are parsed
+	// with html/template, fields of other bodies are escaped for JSON or XML, see handler.ParseNoUpstreamBody.
+	Body string `json:"body"`
+}
+
+func (n *NoUpstreamConfig) applyDefaults() {
+	if n.StatusCode == 0 {
+		n.StatusCode = http.StatusServiceUnavailable
+	}
+	if n.ContentType == "" {
+		n.ContentType = DefaultNoUpstreamContentType
+	}
+	if n.Body == "" {
+		n.Body = DefaultNoUpstreamBody
+	}
+}
+
+func (n *NoUpstreamConfig) validate(path string, errs *ValidationErrors) {
+	// zero is replaced by default status code
+	if n.StatusCode != 0 && (n.StatusCode < 400 || n.StatusCode > 599) {
+		errs.add(path+".status_code", "must be between 400 and 599")
+	}
+	if n.RetryAfter < 0 {
+		errs.add(path+".retry_after", "must not be negative")
+	}
+	if _, err := handler.ParseNoUpstreamBody(n.ContentType, n.Body); err != nil {
+		errs.add(path+".body", "invalid template: %s", err)
+	}
+}
+
+func (n *NoUpstreamConfig) GetResponse() (*handler.NoUpstreamResponse, error) {
+	body, err := handler.ParseNoUpstreamBody(n.ContentType, n.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &handler.NoUpstreamResponse{
+		StatusCode:  n.StatusCode,
+		RetryAfter:  time.Duration(n.RetryAfter),
+		ContentType: n.ContentType,
+		Body:        body,
+	}, nil
+}
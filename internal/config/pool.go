@@ -0,0 +1,183 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"go.uber.org/atomic"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+const (
+	DefaultStrategy = "round-robin"
+	DefaultWeight   = 1
+	DefaultScheme   = "http"
+)
+
+// PoolConfig describes servers and strategy used to distribute requests between them.
+type PoolConfig struct {
+	Strategy *StrategyConfig `json:"strategy,omitempty"`
+	Servers  []ServerConfig  `json:"servers,omitempty"`
+	// Sticky configures sticky sessions, nil means default policy.
+	Sticky *StickyConfig `json:"sticky,omitempty"`
+}
+
+type ServerConfig struct {
+	// Host is either host:port or full URL, e.g. https://backend:8443.
+	Host   string             `json:"host"`
+	Scheme string             `json:"scheme,omitempty"`
+	Weight int                `json:"weight"`
+	TLS    *UpstreamTLSConfig `json:"tls,omitempty"`
+}
+
+func (p *PoolConfig) applyDefaults() {
+	if p.Strategy == nil {
+		p.Strategy = &StrategyConfig{}
+	}
+	if p.Strategy.Name == "" {
+		p.Strategy.Name = DefaultStrategy
+	}
+	// default policy pins clients by address, which strategies without sessions do not do
+	if p.Sticky == nil && len(p.Servers) > 0 && !p.sessionless() {
+		p.Sticky = &StickyConfig{}
+	}
+	if p.Sticky != nil {
+		p.Sticky.applyDefaults()
+	}
+	for i := range p.Servers {
+		if p.Servers[i].Weight == 0 {
+			p.Servers[i].Weight = DefaultWeight
+		}
+		if p.Servers[i].Scheme == "" && !strings.Contains(p.Servers[i].Host, "://") {
+			p.Servers[i].Scheme = DefaultScheme
+		}
+	}
+}
+
+// GetLoadStrategy creates strategy registered in package strategy under configured name.
+func (p *PoolConfig) GetLoadStrategy() (model.Strategy, error) {
+	if p.Strategy == nil {
+		return nil, fmt.Errorf("strategy: missing")
+	}
+	strategy, err := p.Strategy.newStrategy()
+	if err != nil {
+		return nil, fmt.Errorf("strategy: %w", err)
+	}
+	return strategy, nil
+}
+
+func (p *PoolConfig) GetServerPool() (*model.ServerPool, error) {
+	return p.UpdateServerPool(nil, nil)
+}
+
+// UpdateServerPool builds server pool from config reusing servers from current pool, which did not change
+// since previous config other than by weight, so their health status, sticky sessions and counters survive
+// config reload. Reused servers keep their current weights, see ChangedWeights. Servers with other changed
+// settings are created anew, but keep health status of their predecessors. Current pool is not modified.
+// Servers of the new pool are in the same order as in config.
+func (p *PoolConfig) UpdateServerPool(current *model.ServerPool, previous *PoolConfig) (*model.ServerPool, error) {
+	if len(p.Servers) < 1 {
+		return nil, fmt.Errorf("servers: there must be at least one server provided")
+	}
+
+	currentServers := make(map[string]*model.Server)
+	if current != nil {
+		for _, server := range current.Servers {
+			currentServers[server.Url.String()] = server
+		}
+	}
+	previousConfigs := make(map[string]ServerConfig)
+	if previous != nil {
+		for _, server := range previous.Servers {
+			if serverUrl, err := server.getUrl(); err == nil {
+				previousConfigs[serverUrl.String()] = server
+			}
+		}
+	}
+
+	servers := make([]*model.Server, 0, len(p.Servers))
+	for i, server := range p.Servers {
+		serverUrl, err := server.getUrl()
+		if err != nil {
+			return nil, fmt.Errorf("servers[%d].host: %w", i, err)
+		}
+
+		previousServer, ok := currentServers[serverUrl.String()]
+		if previousConfig, found := previousConfigs[serverUrl.String()]; ok && found && sameExceptWeight(previousConfig, server) {
+			servers = append(servers, previousServer)
+			continue
+		}
+
+		alive := true
+		if ok {
+			alive = previousServer.IsAlive()
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if server.TLS != nil {
+			transport, err = server.TLS.getTransport()
+			if err != nil {
+				return nil, fmt.Errorf("servers[%d].tls: %w", i, err)
+			}
+		}
+
+		newServer := &model.Server{
+			Url:    serverUrl,
+			Alive:  atomic.NewBool(alive),
+			Proxy:  httputil.NewSingleHostReverseProxy(serverUrl),
+			Weight: atomic.NewInt64(int64(server.Weight)),
+		}
+		newServer.TrackConnections(transport)
+		newServer.Proxy.Transport = transport
+		servers = append(servers, newServer)
+	}
+
+	// sessions of servers kept by reload stay pinned
+	var sessions *model.SessionStore
+	if p.usesSessions() {
+		if current != nil && current.Sessions != nil {
+			sessions = current.Sessions.Clone(servers, p.Sticky.getPolicy())
+		} else {
+			sessions = model.NewSessionStore(servers, p.Sticky.getPolicy())
+		}
+	}
+
+	return &model.ServerPool{
+		Servers:  servers,
+		Sessions: sessions,
+	}, nil
+}
+
+// ChangedWeights returns weights from config of servers of pool built by UpdateServerPool, which
+// differ from their current weights. Weights of reused servers are changed in place, so they must be
+// applied only when the whole reload succeeded.
+func (p *PoolConfig) ChangedWeights(serverPool *model.ServerPool) map[*model.Server]int {
+	changed := make(map[*model.Server]int)
+	for i, server := range serverPool.Servers {
+		if weight := p.Servers[i].Weight; server.GetWeight() != weight {
+			changed[server] = weight
+		}
+	}
+	return changed
+}
+
+func sameExceptWeight(a ServerConfig, b ServerConfig) bool {
+	a.Weight, b.Weight = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+func (s ServerConfig) getUrl() (*url.URL, error) {
+	if strings.Contains(s.Host, "://") {
+		return url.Parse(s.Host)
+	}
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = DefaultScheme
+	}
+	return url.Parse(scheme + "://" + s.Host)
+}
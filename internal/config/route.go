@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPoolName refers to the pool configured with top-level strategy and servers.
+const DefaultPoolName = "default"
+
+// RouteConfig sends matching requests to a named pool. Route matches when all of its conditions
+// match, route without conditions matches every request. When more routes match, the most specific
+// one wins: exact host beats wildcard host, which beats no host, then longer path match wins, then
+// route with more method and header conditions. Remaining ties are resolved by order in config.
+// Requests matching no route go to the default pool.
+type RouteConfig struct {
+	Name string `json:"name"`
+	// Host is matched against Host header without port, it can start with *. to match subdomains.
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	PathRegex  string `json:"path_regex,omitempty"`
+	// Methods match when request has any of them.
+	Methods []string `json:"methods,omitempty"`
+	// Headers match when request has all of them with exactly given values.
+	Headers map[string]string `json:"headers,omitempty"`
+	Pool    string            `json:"pool"`
+	// StripPrefix removes path_prefix from path before request is proxied.
+	StripPrefix bool `json:"strip_prefix,omitempty"`
+	// Rewrite replaces path_prefix with given value or, for path_regex routes, replaces matches of the
+	// regex with given template, which can reference capture groups, e.g. /v2/$1.
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+func (r *RouteConfig) applyDefaults(i int) {
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("route-%d", i)
+	}
+	for j := range r.Methods {
+		r.Methods[j] = strings.ToUpper(r.Methods[j])
+	}
+}
+
+func (c *Config) validateRoutes(errs *ValidationErrors) {
+	names := make(map[string]int)
+	for i, route := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+
+		if j, ok := names[route.Name]; ok {
+			errs.add(path+".name", "duplicate of routes[%d].name", j)
+		} else {
+			names[route.Name] = i
+		}
+
+		if route.Host != "" && strings.Contains(strings.TrimPrefix(route.Host, "*."), "*") {
+			errs.add(path+".host", "wildcard is allowed only as the first label, e.g. *.example.com")
+		}
+
+		if route.PathPrefix != "" && route.PathRegex != "" {
+			errs.add(path, "path_prefix and path_regex cannot be used together")
+		}
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			errs.add(path+".path_prefix", "must start with /")
+		}
+		if route.PathRegex != "" {
+			if _, err := regexp.Compile(route.PathRegex); err != nil {
+				errs.add(path+".path_regex", "invalid: %s", err)
+			}
+		}
+		if route.StripPrefix && route.PathPrefix == "" {
+			errs.add(path+".strip_prefix", "requires path_prefix")
+		}
+		if route.StripPrefix && route.Rewrite != "" {
+			errs.add(path+".rewrite", "cannot be used together with strip_prefix")
+		}
+		if route.Rewrite != "" && route.PathPrefix == "" && route.PathRegex == "" {
+			errs.add(path+".rewrite", "requires path_prefix or path_regex")
+		}
+
+		for j, method := range route.Methods {
+			if !validMethod(method) {
+				errs.add(fmt.Sprintf("%s.methods[%d]", path, j), "invalid method %q", method)
+			}
+		}
+		for _, name := range sortedKeys(route.Headers) {
+			if name == "" || !validCookieName(name) {
+				errs.add(path+".headers", "invalid header name %q", name)
+			}
+		}
+
+		if route.Pool == "" {
+			errs.add(path+".pool", "missing")
+		} else if route.Pool == DefaultPoolName {
+			if len(c.Servers) == 0 {
+				errs.add(path+".pool", "default pool has no servers")
+			}
+		} else if _, ok := c.Pools[route.Pool]; !ok {
+			errs.add(path+".pool", "unknown pool %q", route.Pool)
+		}
+	}
+}
+
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, r := range method {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
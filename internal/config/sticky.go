@@ -0,0 +1,285 @@
+package config
+
+import (
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajablonsk1/gload-balancer/internal/handler"
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+const (
+	StickyFailover = "failover"
+	StickyFail     = "fail"
+
+	// StickyAddress pins clients by their address.
+	StickyAddress = "address"
+	// StickyCookie pins clients by a signed cookie issued by the balancer.
+	StickyCookie = "cookie"
+	// StickyAppCookie pins clients by a cookie issued by the application.
+	StickyAppCookie = "app-cookie"
+	// StickyHeader pins clients by a header, which application sets in responses and clients
+	// send in requests.
+	StickyHeader = "header"
+
+	DefaultStickyCookieName     = "gload_affinity"
+	DefaultStickyCookiePath     = "/"
+	DefaultStickyCookieSameSite = "lax"
+)
+
+// sessionlessStrategies never look into session store of server pool, they pick a server for
+// every request or keep clients on their servers by hashing.
+var sessionlessStrategies = map[string]bool{
+	"random":              true,
+	"weighted-random":     true,
+	"consistent-hash":     true,
+	"maglev":              true,
+	"least-response-time": true,
+	"p2c":                 true,
+}
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// StickyConfig describes how clients are pinned to servers of a pool.
+type StickyConfig struct {
+	Enabled *bool `json:"enabled"`
+	// Mode is what clients are recognized by: "address", "cookie", "app-cookie" or "header".
+	Mode string `json:"mode"`
+	// Cookie is used in cookie mode.
+	Cookie *StickyCookieConfig `json:"cookie,omitempty"`
+	// Name is name of the cookie in app-cookie mode and name of the header in header mode.
+	Name string `json:"name,omitempty"`
+	// IdleTTL is time after which session not used by any request expires.
+	IdleTTL Duration `json:"idle_ttl"`
+	// AbsoluteTTL is time after which session expires even if it is used, zero means never.
+	AbsoluteTTL Duration `json:"absolute_ttl,omitempty"`
+	// MaxSessionsPerServer limits number of clients pinned to one server, zero means no limit.
+	// Requests of clients over the limit are still served, but not pinned.
+	MaxSessionsPerServer int `json:"max_sessions_per_server,omitempty"`
+	// OnUnhealthy is either "failover", which pins client to another server, or "fail", which
+	// responds with no healthy upstream until pinned server recovers or session expires.
+	OnUnhealthy string `json:"on_unhealthy"`
+}
+
+// StickyCookieConfig describes cookie issued in cookie mode. Its value identifies the server and
+// is signed with Secret. When Secret is empty, random one is generated on start, so cookies issued
+// before restart are ignored.
+type StickyCookieConfig struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain,omitempty"`
+	SameSite string `json:"same_site"`
+	Secure   bool   `json:"secure,omitempty"`
+	// MaxAge is lifetime of cookie, zero means it is removed when browser is closed.
+	MaxAge Duration `json:"max_age,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+func (s *StickyConfig) applyDefaults() {
+	if s.Enabled == nil {
+		enabled := true
+		s.Enabled = &enabled
+	}
+	if s.IdleTTL == 0 {
+		s.IdleTTL = Duration(model.DefaultSessionTTL)
+	}
+	if s.OnUnhealthy == "" {
+		s.OnUnhealthy = StickyFailover
+	}
+	if s.Mode == "" {
+		s.Mode = StickyAddress
+	}
+	if s.Mode == StickyCookie && s.Cookie == nil {
+		s.Cookie = &StickyCookieConfig{}
+	}
+	if s.Cookie != nil {
+		s.Cookie.applyDefaults()
+	}
+}
+
+func (c *StickyCookieConfig) applyDefaults() {
+	if c.Name == "" {
+		c.Name = DefaultStickyCookieName
+	}
+	if c.Path == "" {
+		c.Path = DefaultStickyCookiePath
+	}
+	if c.SameSite == "" {
+		c.SameSite = DefaultStickyCookieSameSite
+	}
+}
+
+func (s *StickyConfig) validate(path string, errs *ValidationErrors) {
+	if s.IdleTTL < 0 {
+		errs.add(path+".idle_ttl", "must not be negative")
+	}
+	if s.AbsoluteTTL < 0 {
+		errs.add(path+".absolute_ttl", "must not be negative")
+	}
+	if s.MaxSessionsPerServer < 0 {
+		errs.add(path+".max_sessions_per_server", "must not be negative")
+	}
+	if s.OnUnhealthy != "" && s.OnUnhealthy != StickyFailover && s.OnUnhealthy != StickyFail {
+		errs.add(path+".on_unhealthy", "unknown value %q, must be %s or %s", s.OnUnhealthy, StickyFailover, StickyFail)
+	}
+	switch s.Mode {
+	case "", StickyAddress:
+	case StickyCookie:
+		if s.Cookie != nil {
+			s.Cookie.validate(path+".cookie", errs)
+		}
+	case StickyAppCookie, StickyHeader:
+		if s.Name == "" {
+			errs.add(path+".name", "missing, %s mode requires name of the %s", s.Mode, strings.TrimPrefix(s.Mode, "app-"))
+		} else if !validCookieName(s.Name) {
+			errs.add(path+".name", "invalid %s name %q", strings.TrimPrefix(s.Mode, "app-"), s.Name)
+		}
+	default:
+		errs.add(path+".mode", "unknown mode %q, must be %s, %s, %s or %s", s.Mode, StickyAddress, StickyCookie, StickyAppCookie, StickyHeader)
+	}
+	if s.Cookie != nil && s.Mode != StickyCookie {
+		errs.add(path+".cookie", "requires %s mode", StickyCookie)
+	}
+	if s.Name != "" && s.Mode != StickyAppCookie && s.Mode != StickyHeader {
+		errs.add(path+".name", "requires %s or %s mode", StickyAppCookie, StickyHeader)
+	}
+}
+
+func (c *StickyCookieConfig) validate(path string, errs *ValidationErrors) {
+	if c.Name != "" && !validCookieName(c.Name) {
+		errs.add(path+".name", "invalid cookie name %q", c.Name)
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		errs.add(path+".path", "must start with /")
+	}
+	if sameSite, ok := sameSiteModes[strings.ToLower(c.SameSite)]; c.SameSite != "" && !ok {
+		errs.add(path+".same_site", "unknown value %q, must be lax, strict or none", c.SameSite)
+	} else if sameSite == http.SameSiteNoneMode && !c.Secure {
+		errs.add(path+".same_site", "none requires secure cookie")
+	}
+	if c.MaxAge < 0 {
+		errs.add(path+".max_age", "must not be negative")
+	}
+}
+
+// validCookieName reports whether name is a token, as names of cookies and headers must be.
+func validCookieName(name string) bool {
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *StickyConfig) isEnabled() bool {
+	return s == nil || s.Enabled == nil || *s.Enabled
+}
+
+// pinsByAddress tells whether clients are pinned by their address.
+func (s *StickyConfig) pinsByAddress() bool {
+	return s.isEnabled() && (s == nil || s.Mode == "" || s.Mode == StickyAddress)
+}
+
+// usesSessions tells whether clients are pinned by address in session store of server pool.
+func (p *PoolConfig) usesSessions() bool {
+	return p.Sticky.pinsByAddress() && !p.sessionless()
+}
+
+// sessionless tells whether strategy of pool ignores session store.
+func (p *PoolConfig) sessionless() bool {
+	return p.Strategy != nil && sessionlessStrategies[p.Strategy.Name]
+}
+
+// getPolicy returns session policy, nil config means default one.
+func (s *StickyConfig) getPolicy() model.SessionPolicy {
+	if s == nil {
+		return model.DefaultSessionPolicy
+	}
+	policy := model.SessionPolicy{
+		IdleTTL:         time.Duration(s.IdleTTL),
+		AbsoluteTTL:     time.Duration(s.AbsoluteTTL),
+		MaxPerServer:    s.MaxSessionsPerServer,
+		FailOnUnhealthy: s.OnUnhealthy == StickyFail,
+	}
+	if policy.IdleTTL == 0 {
+		policy.IdleTTL = model.DefaultSessionTTL
+	}
+	return policy
+}
+
+var (
+	generatedSecretOnce sync.Once
+	generatedSecret     []byte
+)
+
+// secret returns configured secret or the one generated on start.
+func (c *StickyCookieConfig) secret() []byte {
+	if c.Secret != "" {
+		return []byte(c.Secret)
+	}
+	generatedSecretOnce.Do(func() {
+		generatedSecret = make([]byte, 32)
+		if _, err := rand.Read(generatedSecret); err != nil {
+			panic("generating sticky cookie secret: " + err.Error())
+		}
+	})
+	return generatedSecret
+}
+
+func (c *StickyCookieConfig) getCookie() http.Cookie {
+	cookie := http.Cookie{
+		Name:     c.Name,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: sameSiteModes[strings.ToLower(c.SameSite)],
+	}
+	if c.MaxAge > 0 {
+		cookie.MaxAge = int((time.Duration(c.MaxAge) + time.Second - 1) / time.Second)
+	}
+	return cookie
+}
+
+// GetAffinity returns affinity of server pool, nil when clients are pinned by address or not at all.
+// Learned sessions of current affinity are kept for servers which are still in the pool.
+func (p *PoolConfig) GetAffinity(serverPool *model.ServerPool, current handler.Affinity) handler.Affinity {
+	sticky := p.Sticky
+	if sticky == nil || !sticky.isEnabled() {
+		return nil
+	}
+
+	switch sticky.Mode {
+	case StickyCookie:
+		cookie := sticky.Cookie
+		if cookie == nil {
+			cookie = &StickyCookieConfig{}
+			cookie.applyDefaults()
+		}
+		return handler.NewCookieAffinity(cookie.getCookie(), cookie.secret(), serverPool.Servers, sticky.OnUnhealthy == StickyFail)
+	case StickyAppCookie, StickyHeader:
+		var cookie, header string
+		if sticky.Mode == StickyAppCookie {
+			cookie = sticky.Name
+		} else {
+			header = sticky.Name
+		}
+
+		var sessions *model.SessionStore
+		if learned, ok := current.(*handler.LearnedAffinity); ok && learned.Cookie == cookie && learned.Header == header {
+			sessions = learned.Sessions().Clone(serverPool.Servers, sticky.getPolicy())
+		} else {
+			sessions = model.NewSessionStore(serverPool.Servers, sticky.getPolicy())
+		}
+		return handler.NewLearnedAffinity(cookie, header, sessions)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+	"github.com/ajablonsk1/gload-balancer/pkg/strategy"
+)
+
+// StrategyConfig names strategy registered in package strategy. In config it is either the name,
+// e.g. "ip-hash", or an object with name and params of the strategy.
+type StrategyConfig struct {
+	Name   string
+	Params map[string]interface{}
+}
+
+type strategyObject struct {
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+func (s StrategyConfig) MarshalJSON() ([]byte, error) {
+	if len(s.Params) == 0 {
+		return json.Marshal(s.Name)
+	}
+	return json.Marshal(strategyObject{Name: s.Name, Params: s.Params})
+}
+
+func (s *StrategyConfig) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*s = StrategyConfig{Name: name}
+		return nil
+	}
+	var object strategyObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("expected strategy name or object with name and params")
+	}
+	*s = StrategyConfig(object)
+	return nil
+}
+
+func (s *StrategyConfig) checkNode(node interface{}) error {
+	switch node := node.(type) {
+	case string:
+		return nil
+	case map[string]interface{}:
+		for _, key := range sortedKeys(node) {
+			switch key {
+			case "name":
+				if _, ok := node[key].(string); !ok {
+					return fmt.Errorf("name: expected string, got %s", describe(node[key]))
+				}
+			case "params":
+				if _, ok := node[key].(map[string]interface{}); !ok && node[key] != nil {
+					return fmt.Errorf("params: expected object, got %s", describe(node[key]))
+				}
+			default:
+				return fmt.Errorf("unknown field %q, expected name and params", key)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("expected strategy name or object with name and params, got %s", describe(node))
+}
+
+func (s StrategyConfig) validate(path string, errs *ValidationErrors) {
+	if !strategy.Registered(s.Name) {
+		errs.add(path, "%s", strategy.UnknownError(s.Name))
+		return
+	}
+	if _, err := strategy.New(s.Name, s.Params); err != nil {
+		errs.add(path, "%s", err)
+	}
+}
+
+func (s StrategyConfig) newStrategy() (model.Strategy, error) {
+	return strategy.New(s.Name, s.Params)
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/ajablonsk1/gload-balancer/internal/certificates"
+)
+
+// UpstreamTLSConfig configures TLS connections from load balancer to a https server.
+type UpstreamTLSConfig struct {
+	// CAFile is a PEM bundle used instead of system roots to verify server certificate.
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are client certificate presented to server for mutual TLS.
+	CertFile   string `json:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+	// InsecureSkipVerify disables verification of server certificate, use only in labs.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+func (t *UpstreamTLSConfig) validate(path string, errs *ValidationErrors) {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs.add(path, "cert_file and key_file must be provided together")
+	}
+}
+
+func (t *UpstreamTLSConfig) getTransport() (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pool, err := loadCertPool(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("loading CA bundle: no certificates found in " + path)
+	}
+	return pool, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+const (
+	DefaultMinTLSVersion = "1.2"
+	DefaultClientAuth    = "none"
+)
+
+// ListenerTLSConfig configures TLS termination on load balancer listener.
+type ListenerTLSConfig struct {
+	// Certificates are chosen by server name sent by client (SNI), the first one is the default.
+	Certificates []CertificateConfig `json:"certificates"`
+	MinVersion   string              `json:"min_version"`
+	// CipherSuites limits cipher suites used with TLS 1.2 and older, names as in crypto/tls,
+	// e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty means Go defaults.
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+	// ClientAuth is one of none, request, require, verify-if-given and require-and-verify.
+	ClientAuth   string `json:"client_auth"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+type CertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+func (t *ListenerTLSConfig) applyDefaults() {
+	if t.MinVersion == "" {
+		t.MinVersion = DefaultMinTLSVersion
+	}
+	if t.ClientAuth == "" {
+		t.ClientAuth = DefaultClientAuth
+	}
+}
+
+func (t *ListenerTLSConfig) validate(path string, errs *ValidationErrors) {
+	if len(t.Certificates) == 0 {
+		errs.add(path+".certificates", "there must be at least one certificate provided")
+	}
+	for i, certificate := range t.Certificates {
+		certificatePath := fmt.Sprintf("%s.certificates[%d]", path, i)
+		if certificate.CertFile == "" {
+			errs.add(certificatePath+".cert_file", "missing")
+		}
+		if certificate.KeyFile == "" {
+			errs.add(certificatePath+".key_file", "missing")
+		}
+	}
+
+	if _, ok := tlsVersions[t.MinVersion]; !ok {
+		errs.add(path+".min_version", "unknown TLS version %q, must be one of 1.0, 1.1, 1.2, 1.3", t.MinVersion)
+	}
+
+	suites := cipherSuites()
+	for i, name := range t.CipherSuites {
+		if _, ok := suites[name]; !ok {
+			errs.add(fmt.Sprintf("%s.cipher_suites[%d]", path, i), "unknown or insecure cipher suite %q", name)
+		}
+	}
+
+	clientAuth, ok := clientAuthTypes[t.ClientAuth]
+	if !ok {
+		errs.add(path+".client_auth", "unknown client auth %q, must be one of none, request, require, verify-if-given, require-and-verify", t.ClientAuth)
+	} else if clientAuth >= tls.VerifyClientCertIfGiven && t.ClientCAFile == "" {
+		errs.add(path+".client_ca_file", "required to verify client certificates")
+	}
+}
+
+// GetTLSConfig loads certificates and builds TLS config for listener. Returned store has to be watched
+// by the caller to pick up certificate changes.
+func (t *ListenerTLSConfig) GetTLSConfig() (*tls.Config, *certificates.Store, error) {
+	pairs := make([]certificates.KeyPair, len(t.Certificates))
+	for i, certificate := range t.Certificates {
+		pairs[i] = certificates.KeyPair{CertFile: certificate.CertFile, KeyFile: certificate.KeyFile}
+	}
+	store, err := certificates.NewStore(pairs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     tlsVersions[t.MinVersion],
+		ClientAuth:     clientAuthTypes[t.ClientAuth],
+	}
+
+	suites := cipherSuites()
+	for _, name := range t.CipherSuites {
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suites[name])
+	}
+
+	if t.ClientCAFile != "" {
+		pool, err := loadCertPool(t.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, store, nil
+}
+
+func cipherSuites() map[string]uint16 {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	return suites
+}
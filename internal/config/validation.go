@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// FieldError describes a single problem with config, Path points to the offending value
+// in JSON path notation, e.g. servers[2].host.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors aggregates all problems found in config.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "invalid config: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationErrors) add(path string, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e ValidationErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the whole config and reports every problem found, not only the first one.
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+
+	if len(c.Listeners) == 0 {
+		validateAddress("address", c.Address, &errs)
+		if c.TLS != nil {
+			c.TLS.validate("tls", &errs)
+		}
+	} else {
+		if c.Address != "" {
+			errs.add("address", "cannot be used together with listeners")
+		}
+		if c.TLS != nil {
+			errs.add("tls", "cannot be used together with listeners, configure tls of each listener")
+		}
+		c.validateListeners(&errs)
+	}
+
+	for _, name := range sortedKeys(c.Pools) {
+		if name == DefaultPoolName {
+			errs.add("pools."+name, "name is reserved for the pool of top-level servers")
+		}
+		pool := c.Pools[name]
+		pool.validate("pools."+name, &errs)
+	}
+	c.validateRoutes(&errs)
+	c.NoHealthyUpstream.validate("no_healthy_upstream", &errs)
+	if c.ClientIP != nil {
+		c.ClientIP.validate("client_ip", &errs)
+	}
+
+	// without routes every request of routed listeners goes to the default pool
+	if (c.usesRoutes() && len(c.Routes) == 0) || len(c.Servers) > 0 {
+		c.PoolConfig.validate("", &errs)
+	}
+
+	return errs.orNil()
+}
+
+// usesRoutes reports whether any listener routes requests, i.e. proxies requests and has no servers of its own.
+func (c *Config) usesRoutes() bool {
+	for _, listener := range c.GetListeners() {
+		if listener.Protocol != ProtocolRedirect && len(listener.Servers) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func validateAddress(path string, address string, errs *ValidationErrors) {
+	if address == "" {
+		errs.add(path, "missing")
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		errs.add(path, "invalid: %s", err)
+	}
+}
+
+func (p *PoolConfig) validate(path string, errs *ValidationErrors) {
+	if p.Strategy == nil {
+		errs.add(join(path, "strategy"), "missing")
+	} else {
+		p.Strategy.validate(join(path, "strategy"), errs)
+	}
+
+	if len(p.Servers) == 0 {
+		errs.add(join(path, "servers"), "there must be at least one server provided")
+	}
+	hosts := make(map[string]int)
+	for i, server := range p.Servers {
+		serverPath := fmt.Sprintf("%s[%d]", join(path, "servers"), i)
+		if server.Host == "" {
+			errs.add(serverPath+".host", "missing")
+		} else if serverUrl, err := server.getUrl(); err != nil {
+			errs.add(serverPath+".host", "invalid: %s", err)
+		} else if j, ok := hosts[serverUrl.String()]; ok {
+			// servers are told apart by URL, e.g. localhost:1111 and http://localhost:1111 are the same
+			errs.add(serverPath+".host", "duplicate of %s[%d].host", join(path, "servers"), j)
+		} else {
+			hosts[serverUrl.String()] = i
+		}
+		if serverUrl, err := server.getUrl(); server.Host != "" && err == nil {
+			if strings.Contains(server.Host, "://") && server.Scheme != "" && server.Scheme != serverUrl.Scheme {
+				errs.add(serverPath+".scheme", "conflicts with scheme %q of host", serverUrl.Scheme)
+			} else if serverUrl.Scheme != "http" && serverUrl.Scheme != "https" {
+				errs.add(serverPath+".scheme", "unsupported scheme %q, must be http or https", serverUrl.Scheme)
+			} else if server.TLS != nil && serverUrl.Scheme != "https" {
+				errs.add(serverPath+".tls", "requires https scheme")
+			}
+			if serverUrl.Host == "" {
+				errs.add(serverPath+".host", "missing host name")
+			}
+		}
+		if server.TLS != nil {
+			server.TLS.validate(serverPath+".tls", errs)
+		}
+		if server.Weight < 0 {
+			errs.add(serverPath+".weight", "must not be negative")
+		}
+	}
+	if p.Sticky != nil {
+		p.Sticky.validate(join(path, "sticky"), errs)
+		if p.sessionless() && p.Sticky.pinsByAddress() {
+			errs.add(join(path, "sticky"), "strategy %s does not pin clients by address, use %s, %s or %s mode or disable sticky sessions", p.Strategy.Name, StickyCookie, StickyAppCookie, StickyHeader)
+		}
+	}
+}
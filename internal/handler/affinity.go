@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+// Affinity pins clients to servers by something else than their address. It is built for one
+// server pool and replaced together with it on reload.
+type Affinity interface {
+	// Server returns server the request is pinned to or nil, when strategy should pick one.
+	Server(r *http.Request) *model.Server
+	// Pin makes next requests of the client go to server. It returns writer which is used
+	// for the response instead of w.
+	Pin(w http.ResponseWriter, r *http.Request, server *model.Server) http.ResponseWriter
+}
+
+// CookieAffinity pins clients with a cookie issued by the balancer. Cookie value identifies the
+// server and is signed, so clients cannot pick a server by forging it.
+type CookieAffinity struct {
+	// Cookie is template of issued cookie, its value is ignored.
+	Cookie          http.Cookie
+	FailOnUnhealthy bool
+
+	secret  []byte
+	servers map[string]*model.Server
+}
+
+func NewCookieAffinity(cookie http.Cookie, secret []byte, servers []*model.Server, failOnUnhealthy bool) *CookieAffinity {
+	a := &CookieAffinity{
+		Cookie:          cookie,
+		FailOnUnhealthy: failOnUnhealthy,
+		secret:          secret,
+		servers:         make(map[string]*model.Server, len(servers)),
+	}
+	for _, server := range servers {
+		a.servers[serverID(server)] = server
+	}
+	return a
+}
+
+// serverID identifies server in cookies without revealing its address.
+func serverID(server *model.Server) string {
+	sum := sha256.Sum256([]byte(server.Url.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (a *CookieAffinity) sign(id string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *CookieAffinity) value(server *model.Server) string {
+	id := serverID(server)
+	return id + "." + a.sign(id)
+}
+
+// Server returns server from a valid cookie. Missing and tampered cookies and cookies of servers
+// which are no longer in the pool are ignored, so are cookies of dead servers unless the request
+// should fail.
+func (a *CookieAffinity) Server(r *http.Request) *model.Server {
+	cookie, err := r.Cookie(a.Cookie.Name)
+	if err != nil {
+		return nil
+	}
+	id, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign(id))) {
+		return nil
+	}
+	server, ok := a.servers[id]
+	if !ok || (!server.IsAlive() && !a.FailOnUnhealthy) {
+		return nil
+	}
+	return server
+}
+
+// Pin sets the cookie, unless the request already carries the right one.
+func (a *CookieAffinity) Pin(w http.ResponseWriter, r *http.Request, server *model.Server) http.ResponseWriter {
+	value := a.value(server)
+	if cookie, err := r.Cookie(a.Cookie.Name); err == nil && cookie.Value == value {
+		return w
+	}
+	cookie := a.Cookie
+	cookie.Value = value
+	http.SetCookie(w, &cookie)
+	return w
+}
+
+// LearnedAffinity pins clients by a session cookie or header which servers set in responses.
+// The mapping of values to servers is learned from responses and kept in a session store,
+// so it expires and fails over the same way as sessions of clients pinned by address.
+type LearnedAffinity struct {
+	// Cookie is name of the application cookie, Header is name of the header. Only one is set.
+	Cookie string
+	Header string
+
+	sessions *model.SessionStore
+}
+
+func NewLearnedAffinity(cookie string, header string, sessions *model.SessionStore) *LearnedAffinity {
+	return &LearnedAffinity{
+		Cookie:   cookie,
+		Header:   header,
+		sessions: sessions,
+	}
+}
+
+func (a *LearnedAffinity) Sessions() *model.SessionStore {
+	return a.sessions
+}
+
+func (a *LearnedAffinity) Sweep() int {
+	return a.sessions.Sweep()
+}
+
+// requestKey returns value of the cookie or header sent by client.
+func (a *LearnedAffinity) requestKey(r *http.Request) string {
+	if a.Header != "" {
+		return r.Header.Get(a.Header)
+	}
+	if cookie, err := r.Cookie(a.Cookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// responseKey returns value of the cookie or header set by server.
+func (a *LearnedAffinity) responseKey(header http.Header) string {
+	if a.Header != "" {
+		return header.Get(a.Header)
+	}
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.Name == a.Cookie && cookie.MaxAge >= 0 {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (a *LearnedAffinity) Server(r *http.Request) *model.Server {
+	key := a.requestKey(r)
+	if key == "" {
+		return nil
+	}
+	return a.sessions.Get(key)
+}
+
+// Pin returns writer which learns the value set by server when response header is written.
+func (a *LearnedAffinity) Pin(w http.ResponseWriter, r *http.Request, server *model.Server) http.ResponseWriter {
+	return &learningWriter{ResponseWriter: w, affinity: a, server: server}
+}
+
+type learningWriter struct {
+	http.ResponseWriter
+	affinity *LearnedAffinity
+	server   *model.Server
+	learned  bool
+}
+
+func (w *learningWriter) learn() {
+	if w.learned {
+		return
+	}
+	w.learned = true
+	if key := w.affinity.responseKey(w.Header()); key != "" {
+		w.affinity.sessions.Add(key, w.server)
+	}
+}
+
+func (w *learningWriter) WriteHeader(statusCode int) {
+	// informational responses are followed by the final one
+	if statusCode >= http.StatusOK {
+		w.learn()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *learningWriter) Write(b []byte) (int, error) {
+	w.learn()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *learningWriter) Flush() {
+	w.learn()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the original writer, e.g. to hijack connection
+// of a websocket.
+func (w *learningWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
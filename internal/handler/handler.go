@@ -1,17 +1,134 @@
 package handler
 
 import (
+	"log"
 	"net/http"
+	"net/http/httptrace"
+	"time"
 
+	"go.uber.org/atomic"
+
+	"github.com/ajablonsk1/gload-balancer/internal/clientip"
 	"github.com/ajablonsk1/gload-balancer/internal/model"
 )
 
-type ProxyHandler struct {
-	Strategy   model.LoadDistributionStrategy
+// Upstream is a set of servers together with strategy used to distribute requests between them.
+// It is never modified after creation, config reload swaps whole Upstream instead.
+type Upstream struct {
+	Strategy   model.Strategy
 	ServerPool *model.ServerPool
+	// NoUpstream is sent when there is no healthy server, nil means DefaultNoUpstreamResponse.
+	NoUpstream *NoUpstreamResponse
+	// Affinity pins clients to servers before strategy is asked, nil means only strategy is used.
+	Affinity Affinity
+	// ClientIP finds address of client behind trusted proxies, nil means address of connection is used.
+	ClientIP *clientip.Resolver
+}
+
+// SweepSessions removes expired sessions of server pool and of affinity, if it keeps any.
+func (u *Upstream) SweepSessions() int {
+	removed := u.ServerPool.SweepStickySessions()
+	if sweeper, ok := u.Affinity.(interface{ Sweep() int }); ok {
+		removed += sweeper.Sweep()
+	}
+	return removed
 }
 
-func (h ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	server := h.Strategy.GetServer(h.ServerPool, r.RemoteAddr)
+type ProxyHandler struct {
+	// Name of the pool, used in logs and error responses.
+	Name string
+
+	upstream   atomic.Pointer[Upstream]
+	noUpstream atomic.Uint64
+}
+
+func NewProxyHandler(name string, upstream *Upstream) *ProxyHandler {
+	h := &ProxyHandler{Name: name}
+	h.SetUpstream(upstream)
+	return h
+}
+
+func (h *ProxyHandler) Upstream() *Upstream {
+	return h.upstream.Load()
+}
+
+// SetUpstream atomically replaces strategy and server pool. Requests which already picked
+// a server from the previous upstream finish on it.
+func (h *ProxyHandler) SetUpstream(upstream *Upstream) {
+	h.upstream.Store(upstream)
+}
+
+// NoUpstreamCount returns number of requests which found no healthy server.
+func (h *ProxyHandler) NoUpstreamCount() uint64 {
+	return h.noUpstream.Load()
+}
+
+func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream := h.Upstream()
+	var server *model.Server
+	if upstream.Affinity != nil {
+		server = upstream.Affinity.Server(r)
+	}
+	clientIP := upstream.ClientIP.ClientIP(r)
+	if server == nil {
+		server = upstream.Strategy.Pick(upstream.ServerPool, &model.Request{
+			HTTP:     r,
+			ClientIP: clientIP,
+			Route:    RouteName(r),
+			Attempt:  1,
+		})
+	}
+	// server is dead when client is pinned to it and sticky policy says to fail
+	if server == nil || !server.IsAlive() {
+		h.noUpstream.Inc()
+		// host and path come from the client, quoting keeps them from forging log lines
+		log.Printf("pool %s: no healthy upstream for %s %q from %s", h.Name, r.Method, r.Host+r.URL.Path, clientIP)
+
+		response := upstream.NoUpstream
+		if response == nil {
+			response = DefaultNoUpstreamResponse
+		}
+		response.write(w, r, h.Name)
+		return
+	}
+	if upstream.Affinity != nil {
+		w = upstream.Affinity.Pin(w, r, server)
+	}
+	if observer, ok := upstream.Strategy.(model.LatencyObserver); ok {
+		var finished func()
+		r, finished = observeLatency(r, server, observer)
+		defer finished()
+	}
+	server.InFlight.Inc()
+	defer server.InFlight.Dec()
 	server.Proxy.ServeHTTP(w, r)
 }
+
+// observeLatency returns request which reports time to first byte of response of server to observer
+// and function to call when proxying finishes. It reports failure when server did not respond at all,
+// unless client went away first.
+func observeLatency(r *http.Request, server *model.Server, observer model.LatencyObserver) (*http.Request, func()) {
+	start := time.Now()
+	var responded atomic.Bool
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			responded.Store(true)
+			observer.ObserveLatency(server, time.Since(start))
+		},
+	}
+	finished := func() {
+		if !responded.Load() && r.Context().Err() == nil {
+			observer.ObserveFailure(server, time.Since(start))
+		}
+	}
+	return r.WithContext(httptrace.WithClientTrace(r.Context(), trace)), finished
+}
+
+// Scores returns current scores of servers when strategy ranks them by score, nil otherwise.
+func (h *ProxyHandler) Scores() []model.ServerScore {
+	upstream := h.Upstream()
+	if scorer, ok := upstream.Strategy.(model.Scorer); ok {
+		return scorer.Scores(upstream.ServerPool)
+	}
+	return nil
+}
@@ -0,0 +1,692 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/ajablonsk1/gload-balancer/internal/clientip"
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port string
+		host string
+		want string
+	}{
+		{port: "8443", host: "example.com:8080", want: "https://example.com:8443/path?q=1"},
+		{port: "443", host: "example.com:80", want: "https://example.com/path?q=1"},
+		{port: "443", host: "example.com", want: "https://example.com/path?q=1"},
+		{port: "8443", host: "[::1]:8080", want: "https://[::1]:8443/path?q=1"},
+		{port: "8443", host: "[::1]", want: "https://[::1]:8443/path?q=1"},
+		{port: "443", host: "[::1]", want: "https://[::1]/path?q=1"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/path?q=1", nil)
+		request.Host = test.host
+		response := httptest.NewRecorder()
+
+		(&RedirectHandler{Port: test.port}).ServeHTTP(response, request)
+
+		if response.Code != http.StatusMovedPermanently {
+			t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusMovedPermanently)
+		}
+		if got := response.Header().Get("Location"); got != test.want {
+			t.Errorf("wrong location for host %s. got %s want %s", test.host, got, test.want)
+		}
+	}
+}
+
+// newUpstream returns handler of a pool with one server answering with its name and path of request.
+func newUpstream(t *testing.T, name string) *ProxyHandler {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name+" "+r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+
+	backendUrl, _ := url.Parse(backend.URL)
+	serverPool := &model.ServerPool{
+		Servers: []*model.Server{{
+			Url:    backendUrl,
+			Alive:  atomic.NewBool(true),
+			Proxy:  httputil.NewSingleHostReverseProxy(backendUrl),
+			Weight: atomic.NewInt64(1),
+		}},
+	}
+	return NewProxyHandler(name, &Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool})
+}
+
+// newDeadServerPool returns server pool in which every server is down.
+func newDeadServerPool() *model.ServerPool {
+	serverPool := &model.ServerPool{}
+	for _, host := range []string{"localhost:1111", "localhost:1112"} {
+		serverUrl, _ := url.Parse("http://" + host)
+		serverPool.Servers = append(serverPool.Servers, &model.Server{
+			Url:    serverUrl,
+			Alive:  atomic.NewBool(false),
+			Proxy:  httputil.NewSingleHostReverseProxy(serverUrl),
+			Weight: atomic.NewInt64(1),
+		})
+	}
+	return serverPool
+}
+
+func TestNoHealthyUpstream(t *testing.T) {
+	strategies := map[string]model.Strategy{
+		"round-robin":               &model.RoundRobin{},
+		"weighted-round-robin":      &model.WeightedRoundRobin{},
+		"ip-hash":                   &model.IPHash{},
+		"least-connection":          &model.LeastSession{},
+		"weighted-least-connection": &model.WeightedLeastSession{},
+	}
+
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			proxyHandler := NewProxyHandler("api", &Upstream{Strategy: strategy, ServerPool: newDeadServerPool()})
+			request := httptest.NewRequest(http.MethodGet, "/path", nil)
+			response := httptest.NewRecorder()
+
+			proxyHandler.ServeHTTP(response, request)
+
+			if response.Code != http.StatusServiceUnavailable {
+				t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusServiceUnavailable)
+			}
+			if got := response.Body.String(); got != "no healthy upstream\n" {
+				t.Errorf("wrong body. got %q want %q", got, "no healthy upstream\n")
+			}
+			if got := response.Header().Get("Retry-After"); got != "" {
+				t.Errorf("unexpected Retry-After header %s", got)
+			}
+			if got := proxyHandler.NoUpstreamCount(); got != 1 {
+				t.Errorf("wrong no upstream count. got %d want %d", got, 1)
+			}
+		})
+	}
+
+	t.Run("quotes request in log", func(t *testing.T) {
+		var logged strings.Builder
+		log.SetOutput(&logged)
+		defer log.SetOutput(os.Stderr)
+		proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: newDeadServerPool()})
+		request := httptest.NewRequest(http.MethodGet, "/path", nil)
+		request.Host = "example.com\nforged line"
+
+		proxyHandler.ServeHTTP(httptest.NewRecorder(), request)
+
+		if want := `no healthy upstream for GET "example.com\nforged line/path"`; !strings.Contains(logged.String(), want) {
+			t.Errorf("wrong log. got %q want it to contain %q", logged.String(), want)
+		}
+	})
+
+	t.Run("client pinned to dead server", func(t *testing.T) {
+		serverPool := newDeadServerPool()
+		serverPool.Servers[1].SetAlive(true)
+		serverPool.Sessions = model.NewSessionStore(serverPool.Servers, model.SessionPolicy{IdleTTL: time.Minute, FailOnUnhealthy: true})
+		serverPool.AddStickySession("192.0.2.1", serverPool.Servers[0])
+		proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool})
+		request := httptest.NewRequest(http.MethodGet, "/path", nil)
+		response := httptest.NewRecorder()
+
+		proxyHandler.ServeHTTP(response, request)
+
+		if response.Code != http.StatusServiceUnavailable {
+			t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("custom response", func(t *testing.T) {
+		proxyHandler := NewProxyHandler("api", &Upstream{
+			Strategy:   &model.RoundRobin{},
+			ServerPool: newDeadServerPool(),
+			NoUpstream: &NoUpstreamResponse{
+				StatusCode:  http.StatusBadGateway,
+				RetryAfter:  1500 * time.Millisecond,
+				ContentType: "application/json",
+				Body:        template.Must(template.New("body").Parse(`{"pool":"{{.Pool}}","path":"{{.Path}}"}`)),
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			request := httptest.NewRequest(http.MethodGet, "/users", nil)
+			response := httptest.NewRecorder()
+
+			proxyHandler.ServeHTTP(response, request)
+
+			if response.Code != http.StatusBadGateway {
+				t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusBadGateway)
+			}
+			if got := response.Header().Get("Retry-After"); got != "2" {
+				t.Errorf("wrong Retry-After header. got %s want %s", got, "2")
+			}
+			if got := response.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("wrong content type. got %s want %s", got, "application/json")
+			}
+			if got, want := response.Body.String(), `{"pool":"api","path":"/users"}`; got != want {
+				t.Errorf("wrong body. got %s want %s", got, want)
+			}
+		}
+		if got := proxyHandler.NoUpstreamCount(); got != 3 {
+			t.Errorf("wrong no upstream count. got %d want %d", got, 3)
+		}
+	})
+
+	t.Run("escapes request fields", func(t *testing.T) {
+		tests := []struct {
+			contentType string
+			body        string
+			want        string
+		}{
+			{
+				contentType: "text/html; charset=utf-8",
+				body:        `<p>{{.Path}}</p>`,
+				want:        `<p>/&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;</p>`,
+			},
+			{
+				contentType: "application/json",
+				body:        `{"path":"{{.Path}}"}`,
+				want:        `{"path":"/\"\u003e\u003cscript\u003ealert(1)\u003c/script\u003e"}`,
+			},
+			{
+				contentType: "application/xml",
+				body:        `<path>{{.Path}}</path>`,
+				want:        `<path>/&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;</path>`,
+			},
+			{
+				contentType: "text/plain",
+				body:        `{{.Path}}`,
+				want:        `/"><script>alert(1)</script>`,
+			},
+		}
+		for _, test := range tests {
+			body, err := ParseNoUpstreamBody(test.contentType, test.body)
+			if err != nil {
+				t.Fatalf("error while parsing body: %s", err)
+			}
+			proxyHandler := NewProxyHandler("api", &Upstream{
+				Strategy:   &model.RoundRobin{},
+				ServerPool: newDeadServerPool(),
+				NoUpstream: &NoUpstreamResponse{StatusCode: http.StatusServiceUnavailable, ContentType: test.contentType, Body: body},
+			})
+			request := httptest.NewRequest(http.MethodGet, "/%22%3E%3Cscript%3Ealert(1)%3C/script%3E", nil)
+			response := httptest.NewRecorder()
+
+			proxyHandler.ServeHTTP(response, request)
+
+			if got := response.Body.String(); got != test.want {
+				t.Errorf("wrong %s body. got %s want %s", test.contentType, got, test.want)
+			}
+		}
+	})
+}
+
+func TestRouter(t *testing.T) {
+	exact, wildcard, short, long, regex, post, fallback :=
+		newUpstream(t, "exact"), newUpstream(t, "wildcard"), newUpstream(t, "short"), newUpstream(t, "long"),
+		newUpstream(t, "regex"), newUpstream(t, "post"), newUpstream(t, "fallback")
+	router := NewRouter([]*Route{
+		{Name: "short", PathPrefix: "/api", Upstream: short},
+		{Name: "long", PathPrefix: "/api/v1/", StripPrefix: true, Upstream: long},
+		{Name: "post", PathPrefix: "/api", Methods: []string{http.MethodPost}, Headers: map[string]string{"X-Env": "canary"}, Upstream: post},
+		{Name: "wildcard", Host: "*.example.com", Upstream: wildcard},
+		{Name: "exact", Host: "api.example.com", PathPrefix: "/", Upstream: exact},
+		{Name: "regex", PathRegex: regexp.MustCompile(`^/img/(\w+)\.png$`), Rewrite: "/images/$1", Upstream: regex},
+	}, fallback)
+
+	tests := []struct {
+		method  string
+		host    string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{method: http.MethodGet, host: "lb.local", path: "/api/users", want: "short /api/users"},
+		{method: http.MethodGet, host: "lb.local", path: "/api/v1/users", want: "long /users"},
+		{method: http.MethodPost, host: "lb.local", path: "/api/users", headers: map[string]string{"X-Env": "canary"}, want: "post /api/users"},
+		{method: http.MethodPost, host: "lb.local", path: "/api/users", want: "short /api/users"},
+		{method: http.MethodGet, host: "www.example.com:8080", path: "/api/users", want: "wildcard /api/users"},
+		{method: http.MethodGet, host: "API.example.com", path: "/api/users", want: "exact /api/users"},
+		{method: http.MethodGet, host: "example.com", path: "/img/logo.png", want: "regex /images/logo"},
+		{method: http.MethodGet, host: "lb.local", path: "/index.html", want: "fallback /index.html"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.Host = test.host
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+		response := httptest.NewRecorder()
+
+		router.ServeHTTP(response, request)
+
+		if got := response.Body.String(); got != test.want {
+			t.Errorf("wrong upstream for %s %s%s. got %s want %s", test.method, test.host, test.path, got, test.want)
+		}
+	}
+
+	t.Run("returns 404 without fallback", func(t *testing.T) {
+		router.SetRoutes(nil, nil)
+		response := httptest.NewRecorder()
+
+		router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if response.Code != http.StatusNotFound {
+			t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusNotFound)
+		}
+	})
+}
+
+// newServerPool returns server pool with servers answering with their names.
+func newServerPool(t *testing.T, names ...string) *model.ServerPool {
+	t.Helper()
+	serverPool := &model.ServerPool{}
+	for _, name := range names {
+		name := name
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(backend.Close)
+
+		backendUrl, _ := url.Parse(backend.URL)
+		serverPool.Servers = append(serverPool.Servers, &model.Server{
+			Url:    backendUrl,
+			Alive:  atomic.NewBool(true),
+			Proxy:  httputil.NewSingleHostReverseProxy(backendUrl),
+			Weight: atomic.NewInt64(1),
+		})
+	}
+	return serverPool
+}
+
+func TestCookieAffinity(t *testing.T) {
+	serverPool := newServerPool(t, "a", "b")
+	cookie := http.Cookie{Name: "affinity", Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	affinity := NewCookieAffinity(cookie, []byte("secret"), serverPool.Servers, false)
+	proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool, Affinity: affinity})
+
+	send := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(response, request)
+		return response
+	}
+	issued := func(response *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range response.Result().Cookies() {
+			if cookie.Name == "affinity" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	first := send()
+	pinned := issued(first)
+	if pinned == nil || !pinned.HttpOnly || pinned.Path != "/" {
+		t.Fatalf("wrong affinity cookie. got %v", pinned)
+	}
+
+	t.Run("honors cookie", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			response := send(pinned)
+			if got, want := response.Body.String(), first.Body.String(); got != want {
+				t.Errorf("wrong server. got %s want %s", got, want)
+			}
+			if cookie := issued(response); cookie != nil {
+				t.Errorf("cookie should not be issued again, got %v", cookie)
+			}
+		}
+	})
+
+	t.Run("ignores tampered cookie", func(t *testing.T) {
+		id, _, _ := strings.Cut(pinned.Value, ".")
+		response := send(&http.Cookie{Name: "affinity", Value: id + ".forged"})
+		if cookie := issued(response); cookie == nil || !strings.Contains(cookie.Value, ".") {
+			t.Errorf("expected new cookie after tampered one, got %v", cookie)
+		}
+	})
+
+	t.Run("re-pins when server is dead", func(t *testing.T) {
+		dead := affinity.Server(requestWithCookie(pinned))
+		dead.SetAlive(false)
+		defer dead.SetAlive(true)
+
+		response := send(pinned)
+		if response.Code != http.StatusOK || response.Body.String() == first.Body.String() {
+			t.Errorf("expected other server, got %d %s", response.Code, response.Body.String())
+		}
+		if cookie := issued(response); cookie == nil || cookie.Value == pinned.Value {
+			t.Errorf("expected cookie of new server, got %v", cookie)
+		}
+	})
+
+	t.Run("fails when server is dead", func(t *testing.T) {
+		failing := NewCookieAffinity(cookie, []byte("secret"), serverPool.Servers, true)
+		proxyHandler.SetUpstream(&Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool, Affinity: failing})
+		dead := failing.Server(requestWithCookie(pinned))
+		dead.SetAlive(false)
+		defer dead.SetAlive(true)
+
+		response := send(pinned)
+		if response.Code != http.StatusServiceUnavailable {
+			t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.AddCookie(cookie)
+	return request
+}
+
+func TestLearnedAffinity(t *testing.T) {
+	t.Run("application cookie", func(t *testing.T) {
+		serverPool := &model.ServerPool{}
+		for _, name := range []string{"a", "b", "c"} {
+			name := name
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := r.Cookie("JSESSIONID"); err != nil {
+					http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session-" + name})
+				}
+				io.WriteString(w, name)
+			}))
+			t.Cleanup(backend.Close)
+			backendUrl, _ := url.Parse(backend.URL)
+			serverPool.Servers = append(serverPool.Servers, &model.Server{
+				Url:    backendUrl,
+				Alive:  atomic.NewBool(true),
+				Proxy:  httputil.NewSingleHostReverseProxy(backendUrl),
+				Weight: atomic.NewInt64(1),
+			})
+		}
+		affinity := NewLearnedAffinity("JSESSIONID", "", model.NewSessionStore(serverPool.Servers, model.DefaultSessionPolicy))
+		proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool, Affinity: affinity})
+
+		response := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+		first := response.Body.String()
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("expected application cookie, got %v", cookies)
+		}
+
+		for i := 0; i < 4; i++ {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.AddCookie(cookies[0])
+			response := httptest.NewRecorder()
+			proxyHandler.ServeHTTP(response, request)
+			if got := response.Body.String(); got != first {
+				t.Errorf("wrong server. got %s want %s", got, first)
+			}
+		}
+
+		pinned := affinity.Sessions().Get(cookies[0].Value)
+		pinned.SetAlive(false)
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(cookies[0])
+		response = httptest.NewRecorder()
+		proxyHandler.ServeHTTP(response, request)
+		if got := response.Body.String(); got == first || response.Code != http.StatusOK {
+			t.Errorf("expected failover to another server, got %d %s", response.Code, got)
+		}
+	})
+
+	t.Run("header", func(t *testing.T) {
+		serverPool := newServerPool(t, "a", "b")
+		affinity := NewLearnedAffinity("", "X-Tenant", model.NewSessionStore(serverPool.Servers, model.DefaultSessionPolicy))
+		// tenant was seen on the second server before
+		affinity.Sessions().Add("acme", serverPool.Servers[1])
+		proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool, Affinity: affinity})
+
+		for i := 0; i < 4; i++ {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("X-Tenant", "acme")
+			response := httptest.NewRecorder()
+			proxyHandler.ServeHTTP(response, request)
+			if got := response.Body.String(); got != "b" {
+				t.Errorf("wrong server. got %s want %s", got, "b")
+			}
+		}
+	})
+}
+
+func TestProxyHandlerClientIP(t *testing.T) {
+	serverPool := newServerPool(t, "a", "b")
+	serverPool.Sessions = model.NewSessionStore(serverPool.Servers, model.DefaultSessionPolicy)
+	resolver := clientip.NewResolver([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, clientip.DefaultHeaders)
+	proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: serverPool, ClientIP: resolver})
+
+	send := func(remoteAddr string, forwardedFor string) string {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		response := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(response, request)
+		return response.Body.String()
+	}
+
+	t.Run("ignores port", func(t *testing.T) {
+		first := send("192.0.2.1:1111", "")
+		for _, remoteAddr := range []string{"192.0.2.1:2222", "192.0.2.1:3333"} {
+			if got := send(remoteAddr, ""); got != first {
+				t.Errorf("client moved to other server on new connection. got %s want %s", got, first)
+			}
+		}
+	})
+
+	t.Run("uses address from trusted proxy", func(t *testing.T) {
+		first := send("10.0.0.1:1111", "203.0.113.7")
+		if serverPool.GetServerFromStickySession("203.0.113.7") == nil {
+			t.Errorf("expected session of forwarded client address")
+		}
+		if got := send("10.0.0.2:2222", "203.0.113.7"); got != first {
+			t.Errorf("client moved to other server behind other proxy. got %s want %s", got, first)
+		}
+	})
+
+	t.Run("ignores header from untrusted client", func(t *testing.T) {
+		send("192.0.2.9:1111", "198.51.100.1")
+		if serverPool.GetServerFromStickySession("198.51.100.1") != nil {
+			t.Errorf("forged client address was used")
+		}
+	})
+}
+
+// recordingStrategy picks the first server and remembers requests it was asked about.
+type recordingStrategy struct {
+	requests []*model.Request
+}
+
+func (s *recordingStrategy) Pick(serverPool *model.ServerPool, request *model.Request) *model.Server {
+	s.requests = append(s.requests, request)
+	return serverPool.Servers[0]
+}
+
+func TestProxyHandlerStrategyRequest(t *testing.T) {
+	strategy := &recordingStrategy{}
+	upstream := NewProxyHandler("api", &Upstream{Strategy: strategy, ServerPool: newServerPool(t, "a")})
+	router := NewRouter([]*Route{{Name: "users", PathPrefix: "/users", Upstream: upstream}}, upstream)
+
+	for _, path := range []string{"/users/1", "/index.html"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	if len(strategy.requests) != 2 {
+		t.Fatalf("wrong number of requests. got %d want 2", len(strategy.requests))
+	}
+	routed := strategy.requests[0]
+	if routed.HTTP == nil || routed.HTTP.URL.Path != "/users/1" {
+		t.Errorf("strategy did not get http request")
+	}
+	if routed.ClientIP != "192.0.2.1" || routed.Attempt != 1 || len(routed.Exclude) != 0 {
+		t.Errorf("wrong request. got client %s attempt %d exclude %v", routed.ClientIP, routed.Attempt, routed.Exclude)
+	}
+	if routed.Route != "users" {
+		t.Errorf("wrong route. got %q want %q", routed.Route, "users")
+	}
+	if fallback := strategy.requests[1]; fallback.Route != "" {
+		t.Errorf("wrong route of request sent to fallback. got %q want empty", fallback.Route)
+	}
+}
+
+func TestProxyHandlerInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	server := &model.Server{Url: backendUrl, Alive: atomic.NewBool(true), Proxy: httputil.NewSingleHostReverseProxy(backendUrl), Weight: atomic.NewInt64(1)}
+	proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: &model.ServerPool{Servers: []*model.Server{server}}})
+
+	done := make(chan struct{})
+	go func() {
+		proxyHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+
+	<-started
+	if got := server.InFlight.Load(); got != 1 {
+		t.Errorf("wrong number of requests in flight. got %d want 1", got)
+	}
+	close(release)
+	<-done
+	if got := server.InFlight.Load(); got != 0 {
+		t.Errorf("wrong number of requests in flight after response. got %d want 0", got)
+	}
+}
+
+func TestProxyHandlerUpgradedConnection(t *testing.T) {
+	// backend echoes lines of upgraded connection
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		for {
+			line, err := buffered.ReadString('\n')
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, line)
+		}
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	server := &model.Server{Url: backendUrl, Alive: atomic.NewBool(true), Proxy: httputil.NewSingleHostReverseProxy(backendUrl), Weight: atomic.NewInt64(1)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	server.TrackConnections(transport)
+	server.Proxy.Transport = transport
+	proxyHandler := NewProxyHandler("api", &Upstream{Strategy: &model.RoundRobin{}, ServerPool: &model.ServerPool{Servers: []*model.Server{server}}})
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil || response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("connection was not upgraded, response %v error %v", response, err)
+	}
+	io.WriteString(conn, "ping\n")
+	if line, _ := reader.ReadString('\n'); line != "ping\n" {
+		t.Errorf("wrong echo. got %q want %q", line, "ping\n")
+	}
+
+	if got := server.InFlight.Load(); got != 1 {
+		t.Errorf("wrong number of requests in flight. got %d want 1", got)
+	}
+	if got := server.Connections.Load(); got != 1 {
+		t.Errorf("wrong number of open connections. got %d want 1", got)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for (server.InFlight.Load() != 0 || server.Connections.Load() != 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.InFlight.Load() != 0 || server.Connections.Load() != 0 {
+		t.Errorf("counters not released after connection closed. got %d requests and %d connections",
+			server.InFlight.Load(), server.Connections.Load())
+	}
+}
+
+func TestProxyHandlerLatency(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "slow")
+	}))
+	defer backend.Close()
+
+	backendUrl, _ := url.Parse(backend.URL)
+	server := &model.Server{Url: backendUrl, Alive: atomic.NewBool(true), Proxy: httputil.NewSingleHostReverseProxy(backendUrl), Weight: atomic.NewInt64(1)}
+	proxyHandler := NewProxyHandler("api", &Upstream{Strategy: model.NewPeakEWMA(time.Minute), ServerPool: &model.ServerPool{Servers: []*model.Server{server}}})
+
+	proxyHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	scores := proxyHandler.Scores()
+	if len(scores) != 1 || scores[0].Server != server {
+		t.Fatalf("wrong scores. got %+v", scores)
+	}
+	if scores[0].Latency < 50*time.Millisecond || scores[0].Latency > time.Second {
+		t.Errorf("wrong latency. got %s want about %s", scores[0].Latency, 50*time.Millisecond)
+	}
+
+	proxyHandler.SetUpstream(&Upstream{Strategy: &model.RoundRobin{}, ServerPool: &model.ServerPool{Servers: []*model.Server{server}}})
+	if scores := proxyHandler.Scores(); scores != nil {
+		t.Errorf("strategy without scores should return nil, got %+v", scores)
+	}
+}
+
+func TestProxyHandlerFailureLatency(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backendUrl, _ := url.Parse(backend.URL)
+	// connections to closed server are refused
+	backend.Close()
+
+	server := &model.Server{Url: backendUrl, Alive: atomic.NewBool(true), Proxy: httputil.NewSingleHostReverseProxy(backendUrl), Weight: atomic.NewInt64(1)}
+	server.Proxy.ErrorLog = log.New(io.Discard, "", 0)
+	proxyHandler := NewProxyHandler("api", &Upstream{Strategy: model.NewPeakEWMA(time.Minute), ServerPool: &model.ServerPool{Servers: []*model.Server{server}}})
+	response := httptest.NewRecorder()
+
+	proxyHandler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if response.Code != http.StatusBadGateway {
+		t.Errorf("wrong status code. got %d want %d", response.Code, http.StatusBadGateway)
+	}
+	if got := proxyHandler.Scores()[0].Latency; got < 900*time.Millisecond {
+		t.Errorf("failed request should be recorded with penalty. got %s want about %s", got, time.Second)
+	}
+}
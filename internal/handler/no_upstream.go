@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NoUpstreamResponse is sent to client when strategy finds no healthy server in the pool.
+type NoUpstreamResponse struct {
+	StatusCode int
+	// RetryAfter is sent in Retry-After header, when it is not zero.
+	RetryAfter  time.Duration
+	ContentType string
+	// Body is executed with NoUpstreamData, see ParseNoUpstreamBody.
+	Body Template
+}
+
+// Template is either text/template or html/template.
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+type NoUpstreamData struct {
+	Pool   string
+	Method string
+	Host   string
+	Path   string
+}
+
+var DefaultNoUpstreamResponse = &NoUpstreamResponse{
+	StatusCode:  http.StatusServiceUnavailable,
+	ContentType: "text/plain; charset=utf-8",
+	Body:        template.Must(template.New("body").Parse("no healthy upstream\n")),
+}
+
+// ParseNoUpstreamBody parses body sent with given content type. HTML bodies are parsed with html/template,
+// which escapes fields depending on where they are used. Fields of other bodies come from the client,
+// so they are escaped for JSON or XML, unless the body is plain text.
+func ParseNoUpstreamBody(contentType string, body string) (Template, error) {
+	if isHTML(mediaType(contentType)) {
+		return htmltemplate.New("body").Parse(body)
+	}
+	return template.New("body").Parse(body)
+}
+
+func (n *NoUpstreamResponse) write(w http.ResponseWriter, r *http.Request, pool string) {
+	if n.RetryAfter > 0 {
+		seconds := (n.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+	}
+	w.Header().Set("Content-Type", n.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(n.StatusCode)
+
+	escape := fieldEscaper(n.Body, n.ContentType)
+	data := NoUpstreamData{
+		Pool:   escape(pool),
+		Method: escape(r.Method),
+		Host:   escape(r.Host),
+		Path:   escape(r.URL.Path),
+	}
+	if err := n.Body.Execute(w, data); err != nil {
+		log.Printf("pool %s: executing no healthy upstream body: %s", pool, err)
+	}
+}
+
+// fieldEscaper returns function escaping fields of NoUpstreamData for body of given content type.
+func fieldEscaper(body Template, contentType string) func(string) string {
+	if _, ok := body.(*htmltemplate.Template); ok {
+		return func(s string) string { return s }
+	}
+	switch mediaType := mediaType(contentType); {
+	case mediaType == "text/plain":
+		return func(s string) string { return s }
+	case isHTML(mediaType) || mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return htmltemplate.HTMLEscapeString
+	default:
+		// JSON escaping also keeps fields inside strings of JavaScript and CSS
+		return jsonEscape
+	}
+}
+
+// jsonEscape escapes s to be placed between quotes of JSON string.
+func jsonEscape(s string) string {
+	escaped, _ := json.Marshal(s)
+	return string(escaped[1 : len(escaped)-1])
+}
+
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
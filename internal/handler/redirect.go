@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RedirectHandler permanently redirects every request to the same host and path over https.
+type RedirectHandler struct {
+	// Port of https listener, omitted from redirect URL when it is the default 443.
+	Port string
+}
+
+func (h *RedirectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	} else {
+		// IPv6 address without port, e.g. [::1]
+		host = strings.Trim(host, "[]")
+	}
+	if h.Port != "" && h.Port != "443" {
+		host = net.JoinHostPort(host, h.Port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
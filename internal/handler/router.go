@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/atomic"
+)
+
+// Route sends requests matching all of its conditions to Upstream. Empty conditions match everything.
+type Route struct {
+	Name string
+	// Host is exact host name or *.domain matching any subdomain of domain.
+	Host        string
+	PathPrefix  string
+	PathRegex   *regexp.Regexp
+	Methods     []string
+	Headers     map[string]string
+	StripPrefix bool
+	// Rewrite replaces PathPrefix or matches of PathRegex in path of proxied request.
+	Rewrite  string
+	Upstream *ProxyHandler
+}
+
+// routeScore tells how specific the match of a route is, higher fields take precedence.
+type routeScore struct {
+	host       int
+	path       int
+	conditions int
+}
+
+func (s routeScore) greater(other routeScore) bool {
+	if s.host != other.host {
+		return s.host > other.host
+	}
+	if s.path != other.path {
+		return s.path > other.path
+	}
+	return s.conditions > other.conditions
+}
+
+func (route *Route) match(r *http.Request) (routeScore, bool) {
+	var score routeScore
+
+	if route.Host != "" {
+		host := strings.ToLower(r.Host)
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if suffix, ok := strings.CutPrefix(route.Host, "*"); ok {
+			if len(host) <= len(suffix) || !strings.HasSuffix(host, strings.ToLower(suffix)) {
+				return score, false
+			}
+			score.host = 1
+		} else {
+			if host != strings.ToLower(route.Host) {
+				return score, false
+			}
+			score.host = 2
+		}
+	}
+
+	if route.PathPrefix != "" {
+		if !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			return score, false
+		}
+		score.path = len(route.PathPrefix)
+	} else if route.PathRegex != nil {
+		location := route.PathRegex.FindStringIndex(r.URL.Path)
+		if location == nil {
+			return score, false
+		}
+		score.path = location[1]
+	}
+
+	if len(route.Methods) > 0 {
+		matched := false
+		for _, method := range route.Methods {
+			if r.Method == method {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return score, false
+		}
+		score.conditions++
+	}
+
+	for name, value := range route.Headers {
+		if r.Header.Get(name) != value {
+			return score, false
+		}
+		score.conditions++
+	}
+
+	return score, true
+}
+
+func (route *Route) rewritePath(path string) string {
+	switch {
+	case route.StripPrefix:
+		path = strings.TrimPrefix(path, route.PathPrefix)
+	case route.Rewrite != "" && route.PathPrefix != "":
+		path = route.Rewrite + strings.TrimPrefix(path, route.PathPrefix)
+	case route.Rewrite != "" && route.PathRegex != nil:
+		path = route.PathRegex.ReplaceAllString(path, route.Rewrite)
+	default:
+		return path
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+type routingTable struct {
+	routes   []*Route
+	fallback *ProxyHandler
+}
+
+// Router picks upstream for request by the most specific matching route. Requests matching no route
+// go to fallback upstream, if there is one.
+type Router struct {
+	table atomic.Pointer[routingTable]
+}
+
+func NewRouter(routes []*Route, fallback *ProxyHandler) *Router {
+	router := &Router{}
+	router.SetRoutes(routes, fallback)
+	return router
+}
+
+// SetRoutes atomically replaces routes and fallback upstream.
+func (router *Router) SetRoutes(routes []*Route, fallback *ProxyHandler) {
+	router.table.Store(&routingTable{
+		routes:   routes,
+		fallback: fallback,
+	})
+}
+
+// Match returns the most specific route matching request or nil.
+func (router *Router) Match(r *http.Request) *Route {
+	return router.table.Load().match(r)
+}
+
+func (table *routingTable) match(r *http.Request) *Route {
+	var best *Route
+	var bestScore routeScore
+	for _, route := range table.routes {
+		score, ok := route.match(r)
+		if ok && (best == nil || score.greater(bestScore)) {
+			best, bestScore = route, score
+		}
+	}
+	return best
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	table := router.table.Load()
+	route := table.match(r)
+	if route == nil {
+		if table.fallback != nil {
+			table.fallback.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "no route matches request", http.StatusNotFound)
+		return
+	}
+
+	if path := route.rewritePath(r.URL.Path); path != r.URL.Path {
+		// same as http.StripPrefix, original request is not modified
+		rewritten := new(http.Request)
+		*rewritten = *r
+		rewritten.URL = new(url.URL)
+		*rewritten.URL = *r.URL
+		rewritten.URL.Path = path
+		rewritten.URL.RawPath = ""
+		r = rewritten
+	}
+	route.Upstream.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, route.Name)))
+}
+
+type routeKey struct{}
+
+// RouteName returns name of route which matched request, or empty string when request did not
+// go through router.
+func RouteName(r *http.Request) string {
+	name, _ := r.Context().Value(routeKey{}).(string)
+	return name
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ajablonsk1/gload-balancer/internal/utils"
+)
+
+const (
+	// DefaultRingReplicas is the number of virtual nodes of server with weight 1.
+	DefaultRingReplicas = 128
+	// DefaultLoadFactor lets server take 25% more than its share of requests in flight.
+	DefaultLoadFactor = 1.25
+)
+
+// ConsistentHash places virtual nodes of servers, Replicas times weight of each, on a hash ring
+// and sends request to the first node after hash of its key. Adding or removing a server moves
+// only keys of its own nodes and keys of a dead server spread between all the others.
+//
+// With bounded loads, a server with more requests in flight than LoadFactor times its weighted
+// share of all requests in flight is skipped as well, so a popular key cannot overload a server.
+// Requests in flight are counted by Server.InFlight, which proxy handler keeps up to date.
+// Sticky sessions are not used, the same key lands on the same server as long as the server is
+// alive and within its bound.
+type ConsistentHash struct {
+	Key      HashKey
+	Replicas int
+	// LoadFactor bounds load of server, zero disables the bound.
+	LoadFactor float64
+
+	rings tableCache[*ring]
+}
+
+type ring struct {
+	points []ringPoint
+}
+
+type ringPoint struct {
+	hash   uint64
+	server *Server
+}
+
+func NewConsistentHash(key HashKey, replicas int, loadFactor float64) *ConsistentHash {
+	return &ConsistentHash{Key: key, Replicas: replicas, LoadFactor: loadFactor}
+}
+
+// getRing returns ring of all servers of the pool. Dead servers are skipped by Pick, so the ring
+// is built only once for every pool.
+func (c *ConsistentHash) getRing(serverPool *ServerPool) *ring {
+	return c.rings.get(serverPool, 0, func(*ring) *ring {
+		replicas := c.Replicas
+		if replicas <= 0 {
+			replicas = DefaultRingReplicas
+		}
+		r := &ring{}
+		for _, server := range serverPool.Servers {
+			name := server.Url.String()
+			for i := 0; i < replicas*server.GetWeight(); i++ {
+				r.points = append(r.points, ringPoint{hash: utils.Hash64(name + "#" + strconv.Itoa(i)), server: server})
+			}
+		}
+		sort.Slice(r.points, func(i, j int) bool {
+			return r.points[i].hash < r.points[j].hash
+		})
+		return r
+	})
+}
+
+func (c *ConsistentHash) Pick(serverPool *ServerPool, request *Request) *Server {
+	r := c.getRing(serverPool)
+	if len(r.points) == 0 {
+		return nil
+	}
+	capacity := c.capacity(serverPool, request)
+
+	hash := utils.Hash64(c.Key.Of(request))
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	var fallback *Server
+	for i := 0; i < len(r.points); i++ {
+		server := r.points[(start+i)%len(r.points)].server
+		if !server.IsAlive() || request.Excluded(server) {
+			continue
+		}
+		if capacity == nil || server.InFlight.Load() < capacity(server) {
+			return server
+		}
+		if fallback == nil {
+			fallback = server
+		}
+	}
+	// capacities leave room for one more request in total, but requests picking servers at the
+	// same time may fill it before the scan sees it
+	return fallback
+}
+
+// capacity returns function telling how many requests in flight server can have, or nil when
+// loads are not bounded. The request being picked for is counted in.
+func (c *ConsistentHash) capacity(serverPool *ServerPool, request *Request) func(*Server) int64 {
+	if c.LoadFactor == 0 {
+		return nil
+	}
+	var inFlight int64
+	var weights int
+	for _, server := range serverPool.Servers {
+		if server.IsAlive() && !request.Excluded(server) {
+			inFlight += server.InFlight.Load()
+			weights += server.GetWeight()
+		}
+	}
+	if weights == 0 {
+		return nil
+	}
+	return func(server *Server) int64 {
+		return int64(math.Ceil(c.LoadFactor * float64(inFlight+1) * float64(server.GetWeight()) / float64(weights)))
+	}
+}
@@ -0,0 +1,192 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func pickKeys(strategy Strategy, serverPool *ServerPool, n int) map[string]*Server {
+	picked := make(map[string]*Server, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		picked[key] = strategy.Pick(serverPool, &Request{ClientIP: key})
+	}
+	return picked
+}
+
+func TestConsistentHashAddServer(t *testing.T) {
+	const keys = 10000
+	servers := newSessionServers(11)
+	strategy := NewConsistentHash(HashKey{}, 0, 0)
+
+	before := pickKeys(strategy, &ServerPool{Servers: servers[:10]}, keys)
+	after := pickKeys(strategy, &ServerPool{Servers: servers}, keys)
+
+	moved := 0
+	for key, server := range after {
+		if server == before[key] {
+			continue
+		}
+		moved++
+		if server != servers[10] {
+			t.Fatalf("key %s moved between old servers, from %s to %s", key, before[key].Url, server.Url)
+		}
+	}
+	// the new server should take about 1/11 of keys
+	if fraction := float64(moved) / keys; math.Abs(fraction-1.0/11) > 0.03 {
+		t.Errorf("wrong fraction of moved keys. got %.3f want about %.3f", fraction, 1.0/11)
+	}
+}
+
+func TestConsistentHashWeights(t *testing.T) {
+	servers := newSessionServers(3)
+	servers[0].Weight.Store(int64(2))
+	strategy := NewConsistentHash(HashKey{}, 0, 0)
+
+	counts := make(map[*Server]int)
+	for _, server := range pickKeys(strategy, &ServerPool{Servers: servers}, 10000) {
+		counts[server]++
+	}
+	// weights 2, 1, 1
+	for i, want := range []float64{0.5, 0.25, 0.25} {
+		if got := float64(counts[servers[i]]) / 10000; math.Abs(got-want) > 0.05 {
+			t.Errorf("wrong share of server %d. got %.3f want %.3f", i, got, want)
+		}
+	}
+}
+
+func TestConsistentHashDeadServer(t *testing.T) {
+	servers := newSessionServers(5)
+	serverPool := &ServerPool{Servers: servers}
+	strategy := NewConsistentHash(HashKey{}, 0, 0)
+	before := pickKeys(strategy, serverPool, 5000)
+
+	servers[0].SetAlive(false)
+	after := pickKeys(strategy, serverPool, 5000)
+
+	receivers := make(map[*Server]bool)
+	for key, server := range after {
+		if before[key] != servers[0] {
+			if server != before[key] {
+				t.Fatalf("key %s of alive server moved", key)
+			}
+			continue
+		}
+		if server == servers[0] || server == nil {
+			t.Fatalf("key %s stayed on dead server", key)
+		}
+		receivers[server] = true
+	}
+	if len(receivers) != 4 {
+		t.Errorf("keys of dead server should spread between all alive servers, got %d of them", len(receivers))
+	}
+
+	servers[0].SetAlive(true)
+	for key, server := range pickKeys(strategy, serverPool, 5000) {
+		if server != before[key] {
+			t.Fatalf("key %s did not return to recovered server", key)
+		}
+	}
+}
+
+func TestConsistentHashRing(t *testing.T) {
+	strategy := NewConsistentHash(HashKey{}, 0, 0)
+	serverPool := &ServerPool{Servers: newSessionServers(5)}
+
+	rings := make([]*ring, 50)
+	var wg sync.WaitGroup
+	for i := range rings {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rings[i] = strategy.getRing(serverPool)
+		}(i)
+	}
+	wg.Wait()
+	for _, r := range rings {
+		if r != rings[0] {
+			t.Fatalf("concurrent requests built more than one ring")
+		}
+	}
+
+	serverPool.Servers[0].SetAlive(false)
+	defer serverPool.Servers[0].SetAlive(true)
+	if strategy.getRing(serverPool) != rings[0] {
+		t.Errorf("ring was rebuilt after health change")
+	}
+	if strategy.getRing(&ServerPool{Servers: serverPool.Servers}) == rings[0] {
+		t.Errorf("ring was not rebuilt for new pool")
+	}
+}
+
+func TestConsistentHashBoundedLoads(t *testing.T) {
+	t.Run("spreads popular key", func(t *testing.T) {
+		servers := newSessionServers(4)
+		serverPool := &ServerPool{Servers: servers}
+		strategy := NewConsistentHash(HashKey{}, 0, DefaultLoadFactor)
+
+		// requests of one client never finish
+		const requests = 100
+		for i := 0; i < requests; i++ {
+			server := strategy.Pick(serverPool, &Request{ClientIP: "192.0.2.1"})
+			server.InFlight.Inc()
+		}
+
+		limit := int64(math.Ceil(DefaultLoadFactor * requests / 4))
+		for _, server := range servers {
+			if got := server.InFlight.Load(); got > limit {
+				t.Errorf("server %s exceeds bound. got %d requests want at most %d", server.Url, got, limit)
+			}
+		}
+	})
+
+	t.Run("keeps key on its server below the bound", func(t *testing.T) {
+		servers := newSessionServers(4)
+		serverPool := &ServerPool{Servers: servers}
+		strategy := NewConsistentHash(HashKey{}, 0, DefaultLoadFactor)
+		request := &Request{ClientIP: "192.0.2.1"}
+
+		first := strategy.Pick(serverPool, request)
+		for _, server := range servers {
+			server.InFlight.Store(10)
+		}
+		if got := strategy.Pick(serverPool, request); got != first {
+			t.Errorf("key moved although loads are even. got %s want %s", got.Url, first.Url)
+		}
+	})
+}
+
+func TestConsistentHashKey(t *testing.T) {
+	servers := newSessionServers(8)
+	serverPool := &ServerPool{Servers: servers}
+	key, err := ParseHashKey("header:X-Tenant")
+	if err != nil {
+		t.Fatalf("error parsing hash key: %s", err)
+	}
+	strategy := NewConsistentHash(key, 0, 0)
+
+	pick := func(clientIP string, tenant string) *Server {
+		request := httptest.NewRequest("GET", "/", nil)
+		if tenant != "" {
+			request.Header.Set("X-Tenant", tenant)
+		}
+		return strategy.Pick(serverPool, &Request{HTTP: request, ClientIP: clientIP})
+	}
+
+	first := pick("192.0.2.1", "acme")
+	for i := 2; i < 20; i++ {
+		if got := pick(fmt.Sprintf("192.0.2.%d", i), "acme"); got != first {
+			t.Fatalf("requests of tenant went to different servers")
+		}
+	}
+	if pick("192.0.2.1", "") != pick("192.0.2.1", "") {
+		t.Errorf("requests without header should be hashed by client address")
+	}
+
+	if _, err := ParseHashKey("header:"); err == nil {
+		t.Errorf("expected error for header key without name")
+	}
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEWMADecay is the time after which old latency weighs 1/e of what it did.
+	DefaultEWMADecay = 10 * time.Second
+	// ewmaPenalty is latency assumed for servers with requests in flight but no response yet, so
+	// that a new or long idle server is not flooded before its first response.
+	ewmaPenalty = float64(time.Second)
+)
+
+// LatencyObserver is implemented by strategies which need time to first byte of responses.
+type LatencyObserver interface {
+	ObserveLatency(server *Server, ttfb time.Duration)
+	// ObserveFailure reports request which failed after elapsed time without any response,
+	// e.g. because connection to server was refused or reset.
+	ObserveFailure(server *Server, elapsed time.Duration)
+}
+
+// Inheritor is implemented by strategies keeping state of servers, which should survive config reload.
+type Inheritor interface {
+	// Inherit takes over state of servers of serverPool from previous strategy, when it is of the same kind.
+	Inherit(previous Strategy, serverPool *ServerPool)
+}
+
+// Scorer is implemented by strategies which rank servers by score, lower is better.
+type Scorer interface {
+	Scores(serverPool *ServerPool) []ServerScore
+}
+
+type ServerScore struct {
+	Server   *Server
+	Latency  time.Duration
+	InFlight int64
+	Score    float64
+}
+
+func (s ServerScore) String() string {
+	return fmt.Sprintf("%s latency=%s in_flight=%d score=%.0f", s.Server.Url.Host, s.Latency, s.InFlight, s.Score)
+}
+
+// PeakEWMA picks alive server with the lowest moving average of time to first byte multiplied by
+// requests in flight plus one (Finagle's peak EWMA). Latency above the average replaces it at
+// once, so a server which slows down loses traffic immediately, while lower latency is averaged
+// in with weight growing with time since the last response. The average decays toward zero while
+// server gets no responses, so idle servers are tried again.
+type PeakEWMA struct {
+	Decay time.Duration
+
+	latencies sync.Map // *Server to *peakEWMA
+	// now is replaced in tests, nil means time.Now
+	now func() time.Time
+}
+
+type peakEWMA struct {
+	mu      sync.Mutex
+	latency float64
+	stamp   time.Time
+}
+
+func NewPeakEWMA(decay time.Duration) *PeakEWMA {
+	return &PeakEWMA{Decay: decay}
+}
+
+func (p *PeakEWMA) clock() time.Time {
+	if p.now == nil {
+		return time.Now()
+	}
+	return p.now()
+}
+
+func (p *PeakEWMA) decay() float64 {
+	if p.Decay <= 0 {
+		return float64(DefaultEWMADecay)
+	}
+	return float64(p.Decay)
+}
+
+func (p *PeakEWMA) ewma(server *Server) *peakEWMA {
+	if e, ok := p.latencies.Load(server); ok {
+		return e.(*peakEWMA)
+	}
+	e, _ := p.latencies.LoadOrStore(server, &peakEWMA{stamp: p.clock()})
+	return e.(*peakEWMA)
+}
+
+// observe averages in sample and returns the new average.
+func (p *PeakEWMA) observe(e *peakEWMA, sample float64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := p.clock()
+	elapsed := float64(now.Sub(e.stamp))
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	e.stamp = now
+	if sample > e.latency {
+		e.latency = sample
+	} else {
+		weight := math.Exp(-elapsed / p.decay())
+		e.latency = e.latency*weight + sample*(1-weight)
+	}
+	return e.latency
+}
+
+func (p *PeakEWMA) ObserveLatency(server *Server, ttfb time.Duration) {
+	p.observe(p.ewma(server), float64(ttfb))
+}
+
+// ObserveFailure treats failure as response slower than every previous one. Failing server would
+// otherwise get no samples and its average would decay toward zero, which attracts more requests.
+// Every failure at least doubles the average, so repeated ones push server away quickly.
+func (p *PeakEWMA) ObserveFailure(server *Server, elapsed time.Duration) {
+	e := p.ewma(server)
+	e.mu.Lock()
+	sample := math.Max(math.Max(float64(elapsed), ewmaPenalty), 2*e.latency)
+	e.mu.Unlock()
+	p.observe(e, sample)
+}
+
+// Inherit takes over averages of servers kept by config reload, so they do not start from zero.
+func (p *PeakEWMA) Inherit(previous Strategy, serverPool *ServerPool) {
+	previousEWMA, ok := previous.(*PeakEWMA)
+	if !ok || previousEWMA == p {
+		return
+	}
+	for _, server := range serverPool.Servers {
+		if e, ok := previousEWMA.latencies.Load(server); ok {
+			p.latencies.Store(server, e)
+		}
+	}
+}
+
+func (p *PeakEWMA) score(server *Server) ServerScore {
+	// zero sample decays the average by time since the last response
+	latency := p.observe(p.ewma(server), 0)
+	inFlight := server.InFlight.Load()
+	score := latency * float64(inFlight+1)
+	if latency == 0 && inFlight > 0 {
+		score = ewmaPenalty + float64(inFlight)
+	}
+	return ServerScore{Server: server, Latency: time.Duration(latency), InFlight: inFlight, Score: score}
+}
+
+func (p *PeakEWMA) Pick(serverPool *ServerPool, request *Request) *Server {
+	scores := make(map[*Server]float64, len(serverPool.Servers))
+	return leastLoaded(serverPool, request.Exclude, func(a, b *Server) bool {
+		return p.cachedScore(scores, a) < p.cachedScore(scores, b)
+	})
+}
+
+// cachedScore computes score of server once per pick.
+func (p *PeakEWMA) cachedScore(scores map[*Server]float64, server *Server) float64 {
+	if score, ok := scores[server]; ok {
+		return score
+	}
+	score := p.score(server).Score
+	scores[server] = score
+	return score
+}
+
+// Scores returns current scores of servers, for debugging.
+func (p *PeakEWMA) Scores(serverPool *ServerPool) []ServerScore {
+	scores := make([]ServerScore, len(serverPool.Servers))
+	for i, server := range serverPool.Servers {
+		scores[i] = p.score(server)
+	}
+	return scores
+}
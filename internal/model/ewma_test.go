@@ -0,0 +1,157 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestPeakEWMA returns strategy reading time from returned pointer.
+func newTestPeakEWMA(decay time.Duration) (*PeakEWMA, *time.Time) {
+	now := time.Now()
+	strategy := NewPeakEWMA(decay)
+	strategy.now = func() time.Time { return now }
+	return strategy, &now
+}
+
+func TestPeakEWMALatency(t *testing.T) {
+	servers := newSessionServers(1)
+	strategy, now := newTestPeakEWMA(10 * time.Second)
+	latency := func() time.Duration {
+		return strategy.Scores(&ServerPool{Servers: servers})[0].Latency
+	}
+
+	strategy.ObserveLatency(servers[0], 100*time.Millisecond)
+	strategy.ObserveLatency(servers[0], 10*time.Millisecond)
+	if got := latency(); got != 100*time.Millisecond {
+		t.Errorf("lower latency should be averaged in slowly. got %s want %s", got, 100*time.Millisecond)
+	}
+
+	strategy.ObserveLatency(servers[0], 300*time.Millisecond)
+	if got := latency(); got != 300*time.Millisecond {
+		t.Errorf("peak latency should replace average. got %s want %s", got, 300*time.Millisecond)
+	}
+
+	*now = now.Add(10 * time.Second)
+	strategy.ObserveLatency(servers[0], 100*time.Millisecond)
+	want := 300e6/math.E + 100e6*(1-1/math.E)
+	if got := latency(); math.Abs(float64(got)-want) > float64(time.Microsecond) {
+		t.Errorf("wrong average after decay time. got %s want %s", got, time.Duration(want))
+	}
+
+	*now = now.Add(time.Minute)
+	if got := latency(); got > time.Millisecond {
+		t.Errorf("latency of idle server should decay toward zero. got %s", got)
+	}
+}
+
+func TestPeakEWMAFailure(t *testing.T) {
+	servers := newSessionServers(1)
+	strategy, _ := newTestPeakEWMA(10 * time.Second)
+	latency := func() time.Duration {
+		return strategy.Scores(&ServerPool{Servers: servers})[0].Latency
+	}
+
+	strategy.ObserveLatency(servers[0], 10*time.Millisecond)
+	strategy.ObserveFailure(servers[0], time.Millisecond)
+	if got := latency(); got != time.Second {
+		t.Errorf("failure should count as penalty. got %s want %s", got, time.Second)
+	}
+
+	strategy.ObserveFailure(servers[0], time.Millisecond)
+	if got := latency(); got != 2*time.Second {
+		t.Errorf("repeated failure should double average. got %s want %s", got, 2*time.Second)
+	}
+
+	strategy.ObserveFailure(servers[0], 5*time.Second)
+	if got := latency(); got != 5*time.Second {
+		t.Errorf("slow failure should count with elapsed time. got %s want %s", got, 5*time.Second)
+	}
+}
+
+func TestPeakEWMAInherit(t *testing.T) {
+	servers := newSessionServers(3)
+	previous, _ := newTestPeakEWMA(0)
+	previous.ObserveLatency(servers[0], 50*time.Millisecond)
+	previous.ObserveLatency(servers[1], 80*time.Millisecond)
+	strategy := NewPeakEWMA(0)
+	strategy.now = previous.now
+
+	// servers[1] was removed and servers[2] added by reload
+	serverPool := &ServerPool{Servers: []*Server{servers[0], servers[2]}}
+	strategy.Inherit(previous, serverPool)
+
+	scores := strategy.Scores(serverPool)
+	if scores[0].Latency != 50*time.Millisecond || scores[1].Latency != 0 {
+		t.Errorf("wrong inherited latencies. got %s and %s want %s and 0", scores[0].Latency, scores[1].Latency, 50*time.Millisecond)
+	}
+	if _, ok := strategy.latencies.Load(servers[1]); ok {
+		t.Errorf("latency of removed server should not be inherited")
+	}
+
+	strategy.Inherit(&RoundRobin{}, serverPool)
+	if got := strategy.Scores(serverPool)[0].Latency; got != 50*time.Millisecond {
+		t.Errorf("strategy of other kind should be ignored. got %s", got)
+	}
+}
+
+func TestPeakEWMAPick(t *testing.T) {
+	t.Run("prefers faster server", func(t *testing.T) {
+		servers := newSessionServers(2)
+		serverPool := &ServerPool{Servers: servers}
+		strategy, _ := newTestPeakEWMA(0)
+		strategy.ObserveLatency(servers[0], 50*time.Millisecond)
+		strategy.ObserveLatency(servers[1], 10*time.Millisecond)
+
+		for i := 0; i < 10; i++ {
+			if got := strategy.Pick(serverPool, &Request{}); got != servers[1] {
+				t.Fatalf("wrong server. got %s want %s", got.Url, servers[1].Url)
+			}
+		}
+	})
+
+	t.Run("multiplies latency by requests in flight", func(t *testing.T) {
+		servers := newSessionServers(2)
+		serverPool := &ServerPool{Servers: servers}
+		strategy, _ := newTestPeakEWMA(0)
+		strategy.ObserveLatency(servers[0], 50*time.Millisecond)
+		strategy.ObserveLatency(servers[1], 10*time.Millisecond)
+		// 10ms * (9+1) > 50ms * (0+1)
+		servers[1].InFlight.Store(9)
+
+		if got := strategy.Pick(serverPool, &Request{}); got != servers[0] {
+			t.Errorf("wrong server. got %s want %s", got.Url, servers[0].Url)
+		}
+	})
+
+	t.Run("penalizes server without responses", func(t *testing.T) {
+		servers := newSessionServers(2)
+		serverPool := &ServerPool{Servers: servers}
+		strategy, _ := newTestPeakEWMA(0)
+		strategy.ObserveLatency(servers[0], 50*time.Millisecond)
+		servers[1].InFlight.Store(1)
+
+		if got := strategy.Pick(serverPool, &Request{}); got != servers[0] {
+			t.Errorf("wrong server. got %s want %s", got.Url, servers[0].Url)
+		}
+		scores := strategy.Scores(serverPool)
+		if scores[1].Score <= scores[0].Score || scores[1].InFlight != 1 {
+			t.Errorf("wrong scores. got %+v", scores)
+		}
+	})
+
+	t.Run("skips dead and excluded servers", func(t *testing.T) {
+		servers := newSessionServers(3)
+		serverPool := &ServerPool{Servers: servers}
+		strategy, _ := newTestPeakEWMA(0)
+		strategy.ObserveLatency(servers[0], 10*time.Millisecond)
+		strategy.ObserveLatency(servers[1], 20*time.Millisecond)
+		strategy.ObserveLatency(servers[2], 30*time.Millisecond)
+		servers[0].SetAlive(false)
+
+		request := &Request{Exclude: []*Server{servers[1]}}
+		if got := strategy.Pick(serverPool, request); got != servers[2] {
+			t.Errorf("wrong server. got %v want %s", got, servers[2].Url)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	HashKeyClientIP = "client-ip"
+	HashKeyPath     = "path"
+	// HashKeyHeader and HashKeyCookie are followed by name, e.g. "header:X-Tenant".
+	HashKeyHeader = "header:"
+	HashKeyCookie = "cookie:"
+)
+
+// HashKey tells which part of request hashing strategies hash. Requests without the header or
+// cookie are hashed by client address.
+type HashKey struct {
+	source string
+	name   string
+}
+
+// ParseHashKey parses key, empty key means client address.
+func ParseHashKey(key string) (HashKey, error) {
+	switch {
+	case key == "" || key == HashKeyClientIP:
+		return HashKey{source: HashKeyClientIP}, nil
+	case key == HashKeyPath:
+		return HashKey{source: HashKeyPath}, nil
+	case strings.HasPrefix(key, HashKeyHeader) && len(key) > len(HashKeyHeader):
+		return HashKey{source: HashKeyHeader, name: key[len(HashKeyHeader):]}, nil
+	case strings.HasPrefix(key, HashKeyCookie) && len(key) > len(HashKeyCookie):
+		return HashKey{source: HashKeyCookie, name: key[len(HashKeyCookie):]}, nil
+	}
+	return HashKey{}, fmt.Errorf("unknown hash key %q, must be client-ip, path, header:<name> or cookie:<name>", key)
+}
+
+// Of returns value of key in request.
+func (k HashKey) Of(request *Request) string {
+	if request.HTTP != nil {
+		switch k.source {
+		case HashKeyPath:
+			return request.HTTP.URL.Path
+		case HashKeyHeader:
+			if value := request.HTTP.Header.Get(k.name); value != "" {
+				return value
+			}
+		case HashKeyCookie:
+			if cookie, err := request.HTTP.Cookie(k.name); err == nil && cookie.Value != "" {
+				return cookie.Value
+			}
+		}
+	}
+	return request.ClientIP
+}
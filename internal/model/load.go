@@ -0,0 +1,63 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Metrics counted by least-connection strategies.
+const (
+	LoadRequests    = "requests"
+	LoadConnections = "connections"
+)
+
+// ParseLoadMetric checks metric, empty metric means LoadRequests.
+func ParseLoadMetric(metric string) (string, error) {
+	switch metric {
+	case "", LoadRequests:
+		return LoadRequests, nil
+	case LoadConnections:
+		return LoadConnections, nil
+	}
+	return "", fmt.Errorf("unknown load metric %q, must be requests or connections", metric)
+}
+
+// Load returns requests in flight or open connections of server.
+func (s *Server) Load(metric string) int64 {
+	if metric == LoadConnections {
+		return s.Connections.Load()
+	}
+	return s.InFlight.Load()
+}
+
+// TrackConnections makes transport count its open connections to server in Connections. Idle
+// keep-alive connections count as well as upgraded ones, e.g. websockets, until they are closed.
+func (s *Server) TrackConnections(transport *http.Transport) {
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.Connections.Inc()
+		return &trackedConn{Conn: conn, server: s}, nil
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.server.Connections.Dec() })
+	return c.Conn.Close()
+}
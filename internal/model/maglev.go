@@ -0,0 +1,110 @@
+package model
+
+import (
+	"slices"
+
+	"github.com/ajablonsk1/gload-balancer/internal/utils"
+)
+
+// DefaultMaglevTableSize is prime size of lookup table, it should be at least 100 times bigger
+// than the number of servers.
+const DefaultMaglevTableSize = 65537
+
+// Maglev hashes requests into lookup table filled by alive servers in turns, each server taking
+// entries in its own pseudo-random order and as many entries per turn as its weight (Maglev:
+// A Fast and Reliable Software Network Load Balancer, Eisenbud et al.). Lookup takes constant
+// time, servers get shares of the table matching their weights and a change of alive servers
+// moves few keys between the others. The table is rebuilt when health of servers changes.
+// Clients need no sticky sessions, a key keeps its entry and so its server until the set of
+// alive servers changes.
+type Maglev struct {
+	Key HashKey
+	// TableSize must be prime.
+	TableSize int
+
+	tables tableCache[*maglevTable]
+}
+
+type maglevTable struct {
+	alive   []*Server
+	entries []*Server
+}
+
+func NewMaglev(key HashKey, tableSize int) *Maglev {
+	return &Maglev{Key: key, TableSize: tableSize}
+}
+
+func (m *Maglev) getTable(serverPool *ServerPool) *maglevTable {
+	return m.tables.get(serverPool, healthVersion.Load(), func(previous *maglevTable) *maglevTable {
+		alive := make([]*Server, 0, len(serverPool.Servers))
+		for _, server := range serverPool.Servers {
+			if server.IsAlive() {
+				alive = append(alive, server)
+			}
+		}
+		if previous != nil && slices.Equal(previous.alive, alive) {
+			// health of servers in other pools changed
+			return previous
+		}
+		return &maglevTable{alive: alive, entries: m.populate(alive)}
+	})
+}
+
+// populate fills the table, servers take turns and in each turn take as many entries as their
+// weight, each the next free one in order of its permutation.
+func (m *Maglev) populate(servers []*Server) []*Server {
+	size := uint64(m.TableSize)
+	if size == 0 {
+		size = DefaultMaglevTableSize
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+
+	offsets := make([]uint64, len(servers))
+	skips := make([]uint64, len(servers))
+	for i, server := range servers {
+		name := server.Url.String()
+		offsets[i] = utils.Hash64(name+"#offset") % size
+		skips[i] = utils.Hash64(name+"#skip")%(size-1) + 1
+	}
+
+	entries := make([]*Server, size)
+	next := make([]uint64, len(servers))
+	filled := uint64(0)
+	for {
+		for i, server := range servers {
+			// every server takes at least one entry per turn, so the loop ends
+			for turn := 0; turn < server.GetWeight() || turn == 0; turn++ {
+				entry := (offsets[i] + next[i]*skips[i]) % size
+				for entries[entry] != nil {
+					next[i]++
+					entry = (offsets[i] + next[i]*skips[i]) % size
+				}
+				entries[entry] = server
+				next[i]++
+				filled++
+				if filled == size {
+					return entries
+				}
+			}
+		}
+	}
+}
+
+func (m *Maglev) Pick(serverPool *ServerPool, request *Request) *Server {
+	t := m.getTable(serverPool)
+	if len(t.entries) == 0 {
+		return nil
+	}
+
+	entry := int(utils.Hash64(m.Key.Of(request)) % uint64(len(t.entries)))
+	for i := 0; i < len(t.entries); i++ {
+		server := t.entries[(entry+i)%len(t.entries)]
+		// entries of servers which died since the last rebuild lead to the next alive server
+		if server.IsAlive() && !request.Excluded(server) {
+			return server
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func tableShares(table *maglevTable) map[*Server]int {
+	shares := make(map[*Server]int)
+	for _, server := range table.entries {
+		shares[server]++
+	}
+	return shares
+}
+
+func TestMaglevUniformity(t *testing.T) {
+	const keys = 100000
+	servers := newSessionServers(10)
+	serverPool := &ServerPool{Servers: servers}
+	strategy := NewMaglev(HashKey{}, 0)
+
+	expected := float64(DefaultMaglevTableSize) / float64(len(servers))
+	for server, share := range tableShares(strategy.getTable(serverPool)) {
+		if math.Abs(float64(share)-expected)/expected > 0.01 {
+			t.Errorf("uneven share of table of server %s. got %d want %.0f", server.Url, share, expected)
+		}
+	}
+
+	counts := make(map[*Server]int)
+	for _, server := range pickKeys(strategy, serverPool, keys) {
+		counts[server]++
+	}
+	// chi-squared statistic with 9 degrees of freedom, 21.67 is critical value for p = 0.01
+	chiSquared := 0.0
+	for _, server := range servers {
+		diff := float64(counts[server]) - float64(keys)/10
+		chiSquared += diff * diff / (float64(keys) / 10)
+	}
+	if chiSquared > 21.67 {
+		t.Errorf("keys are not distributed uniformly, chi-squared %.2f, counts %v", chiSquared, counts)
+	}
+}
+
+func TestMaglevWeights(t *testing.T) {
+	servers := newSessionServers(3)
+	servers[0].Weight.Store(int64(3))
+	strategy := NewMaglev(HashKey{}, 0)
+
+	shares := tableShares(strategy.getTable(&ServerPool{Servers: servers}))
+	// weights 3, 1, 1
+	for i, want := range []float64{0.6, 0.2, 0.2} {
+		if got := float64(shares[servers[i]]) / DefaultMaglevTableSize; math.Abs(got-want) > 0.01 {
+			t.Errorf("wrong share of server %d. got %.3f want %.3f", i, got, want)
+		}
+	}
+}
+
+func TestMaglevHealthChange(t *testing.T) {
+	const keys = 10000
+	servers := newSessionServers(10)
+	serverPool := &ServerPool{Servers: servers}
+	strategy := NewMaglev(HashKey{}, 0)
+	before := pickKeys(strategy, serverPool, keys)
+
+	servers[0].SetAlive(false)
+	after := pickKeys(strategy, serverPool, keys)
+
+	if _, ok := tableShares(strategy.tables.current.Load().table)[servers[0]]; ok {
+		t.Errorf("table was not rebuilt without dead server")
+	}
+	moved := 0
+	for key, server := range after {
+		if server == servers[0] || server == nil {
+			t.Fatalf("key %s was sent to dead server", key)
+		}
+		if before[key] != servers[0] && server != before[key] {
+			moved++
+		}
+	}
+	// Maglev trades a little disruption for balance, keys of alive servers mostly stay
+	if fraction := float64(moved) / keys; fraction > 0.05 {
+		t.Errorf("too many keys of alive servers moved. got %.3f want at most 0.05", fraction)
+	}
+
+	servers[0].SetAlive(true)
+	for key, server := range pickKeys(strategy, serverPool, keys) {
+		if server != before[key] {
+			t.Fatalf("key %s did not return after server recovered", key)
+		}
+	}
+
+	t.Run("keeps table when other pool changes", func(t *testing.T) {
+		table := strategy.getTable(serverPool)
+		other := newSessionServers(1)
+		other[0].SetAlive(false)
+
+		if rebuilt := strategy.getTable(serverPool); &rebuilt.entries[0] != &table.entries[0] {
+			t.Errorf("table was rebuilt although alive servers of pool did not change")
+		}
+	})
+}
+
+func TestMaglevExclude(t *testing.T) {
+	servers := newSessionServers(3)
+	serverPool := &ServerPool{Servers: servers}
+	strategy := NewMaglev(HashKey{}, 0)
+
+	request := &Request{ClientIP: "192.0.2.1"}
+	first := strategy.Pick(serverPool, request)
+	request.Exclude = []*Server{first}
+	if got := strategy.Pick(serverPool, request); got == nil || got == first {
+		t.Errorf("wrong server. got %v want other than %s", got, first.Url)
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import "math/rand"
+
+// sampleAttempts is the number of random draws before sample falls back to scanning servers.
+const sampleAttempts = 3
+
+// PowerOfTwoChoices samples two alive servers at random and picks the one with fewer requests in
+// flight relative to its weight. It gets close to least-connection balance at constant cost and
+// without the herd behavior of always picking the least loaded server.
+type PowerOfTwoChoices struct{}
+
+func (p *PowerOfTwoChoices) Pick(serverPool *ServerPool, request *Request) *Server {
+	first := sample(serverPool, request, nil)
+	if first == nil {
+		return nil
+	}
+	second := sample(serverPool, request, first)
+	if second == nil {
+		return first
+	}
+	if lessLoaded(second, first) {
+		return second
+	}
+	return first
+}
+
+// lessLoaded compares requests in flight divided by weight, counting request being picked for.
+func lessLoaded(a, b *Server) bool {
+	return (a.InFlight.Load()+1)*int64(b.GetWeight()) < (b.InFlight.Load()+1)*int64(a.GetWeight())
+}
+
+// sample returns random alive server which is not excluded and is not other. A few random draws
+// find one in a mostly healthy pool, otherwise one of eligible servers is drawn.
+func sample(serverPool *ServerPool, request *Request, other *Server) *Server {
+	serversLength := len(serverPool.Servers)
+	if serversLength == 0 {
+		return nil
+	}
+	eligible := func(server *Server) bool {
+		return server != other && server.IsAlive() && !request.Excluded(server)
+	}
+
+	for i := 0; i < sampleAttempts; i++ {
+		if server := serverPool.Servers[rand.Intn(serversLength)]; eligible(server) {
+			return server
+		}
+	}
+	// drawing from all of them, instead of taking the first one after random position, does not
+	// favor servers following dead ones
+	var eligibleServers []*Server
+	for _, server := range serverPool.Servers {
+		if eligible(server) {
+			eligibleServers = append(eligibleServers, server)
+		}
+	}
+	if len(eligibleServers) == 0 {
+		return nil
+	}
+	return eligibleServers[rand.Intn(len(eligibleServers))]
+}
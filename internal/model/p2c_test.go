@@ -0,0 +1,106 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	strategy := &PowerOfTwoChoices{}
+
+	t.Run("never picks the most loaded server", func(t *testing.T) {
+		servers := newSessionServers(3)
+		servers[1].InFlight.Store(5)
+		servers[2].InFlight.Store(10)
+		serverPool := &ServerPool{Servers: servers}
+
+		counts := make(map[*Server]int)
+		for i := 0; i < 1000; i++ {
+			counts[strategy.Pick(serverPool, &Request{})]++
+		}
+		if counts[servers[2]] != 0 {
+			t.Errorf("most loaded server was picked %d times", counts[servers[2]])
+		}
+		// the least loaded server wins both pairs it is sampled in, 2/3 of picks
+		if counts[servers[0]] < 600 {
+			t.Errorf("least loaded server was picked only %d times of 1000", counts[servers[0]])
+		}
+	})
+
+	t.Run("adjusts load by weight", func(t *testing.T) {
+		servers := newSessionServers(2)
+		servers[0].Weight.Store(int64(4))
+		servers[0].InFlight.Store(3)
+		servers[1].InFlight.Store(1)
+
+		if got := strategy.Pick(&ServerPool{Servers: servers}, &Request{}); got != servers[0] {
+			t.Errorf("wrong server. got %s want %s", got.Url, servers[0].Url)
+		}
+	})
+
+	t.Run("spreads even load", func(t *testing.T) {
+		servers := newSessionServers(4)
+		serverPool := &ServerPool{Servers: servers}
+
+		counts := make(map[*Server]int)
+		for i := 0; i < 4000; i++ {
+			counts[strategy.Pick(serverPool, &Request{})]++
+		}
+		for _, server := range servers {
+			if counts[server] < 800 || counts[server] > 1200 {
+				t.Errorf("uneven picks of server %s. got %d want about 1000", server.Url, counts[server])
+			}
+		}
+	})
+
+	t.Run("skips dead and excluded servers", func(t *testing.T) {
+		servers := newSessionServers(10)
+		for _, server := range servers[:8] {
+			server.SetAlive(false)
+		}
+		serverPool := &ServerPool{Servers: servers}
+		request := &Request{Exclude: []*Server{servers[8]}}
+
+		for i := 0; i < 100; i++ {
+			if got := strategy.Pick(serverPool, request); got != servers[9] {
+				t.Fatalf("wrong server. got %v want %s", got, servers[9].Url)
+			}
+		}
+		request.Exclude = append(request.Exclude, servers[9])
+		if got := strategy.Pick(serverPool, request); got != nil {
+			t.Errorf("wrong server. got %s want nil", got.Url)
+		}
+	})
+}
+
+func benchmarkStrategy(b *testing.B, strategy Strategy, n int) {
+	servers := newSessionServers(n)
+	for i, server := range servers {
+		server.InFlight.Store(int64(i % 7))
+	}
+	serverPool := &ServerPool{Servers: servers}
+	request := &Request{ClientIP: "192.0.2.1"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		strategy.Pick(serverPool, request)
+	}
+}
+
+func BenchmarkPowerOfTwoChoices(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("servers=%d", n), func(b *testing.B) {
+			benchmarkStrategy(b, &PowerOfTwoChoices{}, n)
+		})
+	}
+}
+
+// BenchmarkLeastSession runs without sessions, so that every request scans the whole pool as
+// requests of new clients do.
+func BenchmarkLeastSession(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("servers=%d", n), func(b *testing.B) {
+			benchmarkStrategy(b, &LeastSession{}, n)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"math/rand"
+)
+
+// Random picks alive server uniformly at random. Unlike round robin, many balancers sharing the
+// same servers do not send bursts to them in lockstep.
+type Random struct{}
+
+func (r *Random) Pick(serverPool *ServerPool, request *Request) *Server {
+	return sample(serverPool, request, nil)
+}
+
+// WeightedRandom picks alive server at random with probability proportional to its weight. Picks
+// take constant time with alias table (Vose's method), which is rebuilt when health of servers
+// changes.
+type WeightedRandom struct {
+	tables tableCache[*aliasTable]
+}
+
+type aliasTable struct {
+	servers []*Server
+	// probability of keeping the drawn column, otherwise the pick is its alias
+	probability []float64
+	alias       []int
+}
+
+func newAliasTable(serverPool *ServerPool) *aliasTable {
+	t := &aliasTable{}
+	total := 0
+	for _, server := range serverPool.Servers {
+		if server.IsAlive() && server.GetWeight() > 0 {
+			t.servers = append(t.servers, server)
+			total += server.GetWeight()
+		}
+	}
+
+	n := len(t.servers)
+	t.probability = make([]float64, n)
+	t.alias = make([]int, n)
+	// weights scaled so that their average is 1, columns below 1 are topped up by aliases
+	scaled := make([]float64, n)
+	var small, large []int
+	for i, server := range t.servers {
+		scaled[i] = float64(server.GetWeight()*n) / float64(total)
+		if scaled[i] < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		less, more := small[len(small)-1], large[len(large)-1]
+		small, large = small[:len(small)-1], large[:len(large)-1]
+		t.probability[less] = scaled[less]
+		t.alias[less] = more
+		scaled[more] += scaled[less] - 1
+		if scaled[more] < 1 {
+			small = append(small, more)
+		} else {
+			large = append(large, more)
+		}
+	}
+	// what is left is 1 up to rounding errors
+	for _, i := range append(small, large...) {
+		t.probability[i] = 1
+	}
+	return t
+}
+
+func (w *WeightedRandom) Pick(serverPool *ServerPool, request *Request) *Server {
+	t := w.tables.get(serverPool, healthVersion.Load(), func(*aliasTable) *aliasTable {
+		return newAliasTable(serverPool)
+	})
+	if len(t.servers) == 0 {
+		return nil
+	}
+
+	for i := 0; i < sampleAttempts; i++ {
+		column := rand.Intn(len(t.servers))
+		if rand.Float64() >= t.probability[column] {
+			column = t.alias[column]
+		}
+		// excluded servers and servers which died since the table was taken are drawn again
+		if server := t.servers[column]; server.IsAlive() && !request.Excluded(server) {
+			return server
+		}
+	}
+
+	// most of the weight is excluded, draw from the rest
+	total := 0
+	for _, server := range t.servers {
+		if server.IsAlive() && !request.Excluded(server) {
+			total += server.GetWeight()
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	draw := rand.Intn(total)
+	for _, server := range t.servers {
+		if server.IsAlive() && !request.Excluded(server) {
+			if draw < server.GetWeight() {
+				return server
+			}
+			draw -= server.GetWeight()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package model
+
+import "testing"
+
+// chiSquared returns chi-squared statistic of picks of servers against shares proportional
+// to weights.
+func chiSquared(counts map[*Server]int, servers []*Server, picks int) float64 {
+	total := 0
+	for _, server := range servers {
+		total += server.GetWeight()
+	}
+	statistic := 0.0
+	for _, server := range servers {
+		expected := float64(picks) * float64(server.GetWeight()) / float64(total)
+		diff := float64(counts[server]) - expected
+		statistic += diff * diff / expected
+	}
+	return statistic
+}
+
+func countPicks(strategy Strategy, serverPool *ServerPool, request *Request, picks int) map[*Server]int {
+	counts := make(map[*Server]int)
+	for i := 0; i < picks; i++ {
+		counts[strategy.Pick(serverPool, request)]++
+	}
+	return counts
+}
+
+func TestRandom(t *testing.T) {
+	const picks = 40000
+	servers := newSessionServers(4)
+	serverPool := &ServerPool{Servers: servers}
+	strategy := &Random{}
+
+	t.Run("picks servers uniformly", func(t *testing.T) {
+		counts := countPicks(strategy, serverPool, &Request{}, picks)
+		// 3 degrees of freedom, 16.27 is critical value for p = 0.001
+		if statistic := chiSquared(counts, servers, picks); statistic > 16.27 {
+			t.Errorf("picks are not uniform, chi-squared %.2f, counts %v", statistic, counts)
+		}
+	})
+
+	t.Run("skips dead and excluded servers", func(t *testing.T) {
+		servers[0].SetAlive(false)
+		defer servers[0].SetAlive(true)
+
+		counts := countPicks(strategy, serverPool, &Request{Exclude: []*Server{servers[1]}}, picks)
+		if counts[servers[0]] != 0 || counts[servers[1]] != 0 || counts[nil] != 0 {
+			t.Fatalf("dead or excluded server was picked, counts %v", counts)
+		}
+		// 1 degree of freedom, 10.83 is critical value for p = 0.001
+		if statistic := chiSquared(counts, servers[2:], picks); statistic > 10.83 {
+			t.Errorf("picks are not uniform, chi-squared %.2f, counts %v", statistic, counts)
+		}
+	})
+}
+
+func TestWeightedRandom(t *testing.T) {
+	const picks = 100000
+	servers := newSessionServers(4)
+	for i, weight := range []int{5, 3, 1, 1} {
+		servers[i].Weight.Store(int64(weight))
+	}
+	serverPool := &ServerPool{Servers: servers}
+	strategy := &WeightedRandom{}
+
+	t.Run("picks servers in proportion to weights", func(t *testing.T) {
+		counts := countPicks(strategy, serverPool, &Request{}, picks)
+		// 3 degrees of freedom, 16.27 is critical value for p = 0.001
+		if statistic := chiSquared(counts, servers, picks); statistic > 16.27 {
+			t.Errorf("picks do not follow weights, chi-squared %.2f, counts %v", statistic, counts)
+		}
+	})
+
+	t.Run("rebuilds table when server dies", func(t *testing.T) {
+		servers[0].SetAlive(false)
+		defer servers[0].SetAlive(true)
+
+		counts := countPicks(strategy, serverPool, &Request{}, picks)
+		if counts[servers[0]] != 0 || counts[nil] != 0 {
+			t.Fatalf("dead server was picked, counts %v", counts)
+		}
+		if len(strategy.tables.current.Load().table.servers) != 3 {
+			t.Errorf("table was not rebuilt without dead server")
+		}
+		// 2 degrees of freedom, 13.82 is critical value for p = 0.001
+		if statistic := chiSquared(counts, servers[1:], picks); statistic > 13.82 {
+			t.Errorf("picks do not follow weights of alive servers, chi-squared %.2f, counts %v", statistic, counts)
+		}
+	})
+
+	t.Run("draws from the rest when most weight is excluded", func(t *testing.T) {
+		counts := countPicks(strategy, serverPool, &Request{Exclude: servers[:2]}, picks)
+		if counts[servers[0]] != 0 || counts[servers[1]] != 0 || counts[nil] != 0 {
+			t.Fatalf("excluded server was picked, counts %v", counts)
+		}
+		// 1 degree of freedom, 10.83 is critical value for p = 0.001
+		if statistic := chiSquared(counts, servers[2:], picks); statistic > 10.83 {
+			t.Errorf("picks do not follow weights, chi-squared %.2f, counts %v", statistic, counts)
+		}
+	})
+}
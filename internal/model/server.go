@@ -1,7 +1,6 @@
 package model
 
 import (
-	"cmp"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -11,39 +10,47 @@ import (
 )
 
 type Server struct {
-	Url            *url.URL
-	Alive          *atomic.Bool
-	Proxy          *httputil.ReverseProxy
-	Weight         int
-	StickySessions map[string]time.Time
+	Url    *url.URL
+	Alive  *atomic.Bool
+	Proxy  *httputil.ReverseProxy
+	// Weight is changed in place by config reload, nil means zero.
+	Weight *atomic.Int64
+	// InFlight counts requests being proxied to the server, including streamed responses and
+	// upgraded connections until they end.
+	InFlight atomic.Int64
+	// Connections counts open connections of proxy transport to the server, see TrackConnections.
+	Connections atomic.Int64
 }
 
 func (s *Server) IsAlive() bool {
 	return s.Alive.Load()
 }
 
-func (s *Server) SetAlive(isAlive bool) {
-	s.Alive.Store(isAlive)
-}
-
-func (s *Server) AddStickySession(remoteAddr string) {
-	s.StickySessions[remoteAddr] = time.Now()
+func (s *Server) GetWeight() int {
+	if s.Weight == nil {
+		return 0
+	}
+	return int(s.Weight.Load())
 }
 
-func (s *Server) HasStickySession(remoteAddr string) bool {
-	_, ok := s.StickySessions[remoteAddr]
-	return ok
-}
+// healthVersion changes whenever any server changes health status, so strategies which cache
+// state derived from health of servers can tell when to rebuild it.
+var healthVersion atomic.Uint64
 
-func (s *Server) UpdateTimeForStickySession(remoteAddr string) {
-	s.StickySessions[remoteAddr] = time.Now()
+func (s *Server) SetAlive(isAlive bool) {
+	if s.Alive.Swap(isAlive) != isAlive {
+		healthVersion.Inc()
+	}
 }
 
-func (s *Server) DeleteStickySessionsIfTimeExpired() {
-	for remoteAddr, stickyTime := range s.StickySessions {
-		if stickyTime.Add(10 * time.Minute).Before(time.Now()) {
-			delete(s.StickySessions, remoteAddr)
-		}
+// CloseIdleConnections closes idle connections of proxy transport, when config reload removed or
+// replaced the server. Connections still in use are closed by transport after its idle timeout.
+func (s *Server) CloseIdleConnections() {
+	if s.Proxy == nil {
+		return
+	}
+	if transport, ok := s.Proxy.Transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
 	}
 }
 
@@ -51,6 +58,10 @@ func (s *Server) checkHealth() {
     client := &http.Client{
         Timeout: 2 * time.Second,
     }
+    if s.Proxy != nil {
+        // health checks go through the same transport as proxied requests, so they use the same TLS settings
+        client.Transport = s.Proxy.Transport
+    }
 
     resp, err := client.Get(s.Url.String())
     if err != nil {
@@ -66,21 +77,11 @@ func (s *Server) checkHealth() {
     }
 }
 
-func (s *Server) NumberOfStickySessions() int {
-	return len(s.StickySessions)
-}
-
-func SortByWeight(a, b *Server) int {
-	return cmp.Compare(b.Weight, a.Weight)
-}
-
-func SortByNConnections(a, b *Server) int {
-	return cmp.Compare(a.NumberOfStickySessions(), b.NumberOfStickySessions())
-}
-
 type ServerPool struct {
 	Servers    []*Server
 	CurrentIdx atomic.Uint64
+	// Sessions pins clients to servers, nil disables sticky sessions.
+	Sessions *SessionStore
 }
 
 func (s *ServerPool) NextIndex() int {
@@ -91,25 +92,35 @@ func (s *ServerPool) GetCurrentIdx() int {
 	return int(s.CurrentIdx.Load()) % len(s.Servers)
 }
 
-func (s *ServerPool) OrganizeStickySessions() {
-	for _, server := range s.Servers {
-		if !server.IsAlive() {
-			server.StickySessions = make(map[string]time.Time)
-			continue
-		}
-		server.DeleteStickySessionsIfTimeExpired()
+// GetServerFromStickySession returns server the client is pinned to, if any. The server is dead
+// only when session policy says to fail requests of clients pinned to dead servers.
+func (s *ServerPool) GetServerFromStickySession(remoteAddr string) *Server {
+	if s.Sessions == nil {
+		return nil
 	}
+	return s.Sessions.Get(remoteAddr)
 }
 
-func (s *ServerPool) GetServerFromStickySession(remoteAddr string) *Server {
-	s.OrganizeStickySessions()
-	for _, server := range s.Servers {
-		if server.HasStickySession(remoteAddr) {
-			server.UpdateTimeForStickySession(remoteAddr)
-			return server
-		}
+func (s *ServerPool) AddStickySession(remoteAddr string, server *Server) bool {
+	if s.Sessions == nil {
+		return false
+	}
+	return s.Sessions.Add(remoteAddr, server)
+}
+
+func (s *ServerPool) NumberOfStickySessions(server *Server) int {
+	if s.Sessions == nil {
+		return 0
+	}
+	return s.Sessions.Count(server)
+}
+
+// SweepStickySessions removes expired sessions and sessions of dead servers, unless policy says to fail.
+func (s *ServerPool) SweepStickySessions() int {
+	if s.Sessions == nil {
+		return 0
 	}
-	return nil
+	return s.Sessions.Sweep()
 }
 
 func (s *ServerPool) HealthCheck() {
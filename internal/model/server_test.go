@@ -5,7 +5,6 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
-	"time"
 
 	"go.uber.org/atomic"
 )
@@ -35,83 +34,12 @@ func TestServerSetAlive(t *testing.T) {
 	}
 }
 
-func TestServerAddStickySession(t *testing.T) {
-	server := &Server{
-		StickySessions: make(map[string]time.Time),
-	}
-
-	remoteAddr := "127.0.0.1"
-	server.AddStickySession(remoteAddr)
-
-	if _, ok := server.StickySessions[remoteAddr]; !ok {
-		t.Errorf("Expected sticky session for remote address %s", remoteAddr)
-	}
-}
-
-func TestServerHasStickySession(t *testing.T) {
-	server := &Server{
-		StickySessions: make(map[string]time.Time),
-	}
-
-	remoteAddr := "127.0.0.1"
-	server.StickySessions[remoteAddr] = time.Now()
-
-	if !server.HasStickySession(remoteAddr) {
-		t.Errorf("Expected server to have sticky session for remote address %s", remoteAddr)
-	}
-}
-
-func TestServerUpdateTimeForStickySession(t *testing.T) {
-	server := &Server{
-		StickySessions: make(map[string]time.Time),
-	}
-
-	remoteAddr := "127.0.0.1"
-	server.StickySessions[remoteAddr] = time.Now()
-
-	server.UpdateTimeForStickySession(remoteAddr)
-
-	if server.StickySessions[remoteAddr].Equal(time.Now()) {
-		t.Errorf("Expected sticky session time to be updated for remote address %s", remoteAddr)
-	}
-}
-
-func TestServerDeleteStickySessionsIfTimeExpired(t *testing.T) {
-	server := &Server{
-		StickySessions: make(map[string]time.Time),
-	}
-
-	remoteAddr := "127.0.0.1"
-	server.StickySessions[remoteAddr] = time.Now().Add(-time.Hour)
-
-	server.DeleteStickySessionsIfTimeExpired()
-
-	if _, ok := server.StickySessions[remoteAddr]; ok {
-		t.Errorf("Expected sticky session for remote address %s to be deleted", remoteAddr)
-	}
-}
-
-func TestServerNumberOfStickySessions(t *testing.T) {
-	server := &Server{
-		StickySessions: make(map[string]time.Time),
-	}
-
-	server.StickySessions["127.0.0.1"] = time.Now()
-	server.StickySessions["192.168.0.1"] = time.Now()
-
-	count := server.NumberOfStickySessions()
-
-	if count != 2 {
-		t.Errorf("Expected number of sticky sessions to be 2, got %d", count)
-	}
-}
-
 func TestServerPoolNextIndex(t *testing.T) {
 	serverPool := &ServerPool{
 		Servers: []*Server{
-			{Weight: 1},
-			{Weight: 2},
-			{Weight: 3},
+			{Weight: atomic.NewInt64(1)},
+			{Weight: atomic.NewInt64(2)},
+			{Weight: atomic.NewInt64(3)},
 		},
 		CurrentIdx: *atomic.NewUint64(0),
 	}
@@ -136,52 +64,6 @@ func TestServerPoolGetCurrentIdx(t *testing.T) {
 	}
 }
 
-func TestServerPoolOrganizeStickySessions(t *testing.T) {
-	alive := &atomic.Bool{}
-	alive.Store(true)
-
-	dead := &atomic.Bool{}
-	dead.Store(false)
-
-	serverPool := &ServerPool{
-		Servers: []*Server{
-			{StickySessions: map[string]time.Time{"127.0.0.1": time.Now()}, Alive: atomic.NewBool(false)},
-			{StickySessions: map[string]time.Time{"192.168.0.1": time.Now()}, Alive: atomic.NewBool(true)},
-		},
-	}
-	serverPool.Servers[0].SetAlive(false)
-	serverPool.Servers[1].SetAlive(true)
-
-	serverPool.OrganizeStickySessions()
-
-	if len(serverPool.Servers[0].StickySessions) != 0 {
-		t.Error("Expected first server to have no sticky sessions")
-	}
-
-	if len(serverPool.Servers[1].StickySessions) != 1 {
-		t.Error("Expected second server to have one sticky session")
-	}
-}
-
-func TestServerPoolGetServerFromStickySession(t *testing.T) {
-	alive := &atomic.Bool{}
-	alive.Store(true)
-
-	serverPool := &ServerPool{
-		Servers: []*Server{
-			{StickySessions: map[string]time.Time{"127.0.0.1": time.Now()}, Alive: atomic.NewBool(true)},
-			{StickySessions: map[string]time.Time{"192.168.0.1": time.Now()}, Alive: atomic.NewBool(true)},
-		},
-	}
-
-	remoteAddr := "192.168.0.1"
-	server := serverPool.GetServerFromStickySession(remoteAddr)
-
-	if server == nil {
-		t.Errorf("Expected server to be found for remote address %s", remoteAddr)
-	}
-}
-
 func TestServerPoolHealthCheck(t *testing.T) {
 	url1, _ := url.Parse("http://127.0.0.1:8080")
 	url2, _ := url.Parse("http://127.0.0.1:8081")
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/ajablonsk1/gload-balancer/internal/utils"
+)
+
+const (
+	DefaultSessionTTL = 10 * time.Minute
+	sessionShards     = 32
+)
+
+// SessionPolicy describes lifetime of sessions and what happens to them when server dies.
+type SessionPolicy struct {
+	// IdleTTL is time after which session not used by any request expires.
+	IdleTTL time.Duration
+	// AbsoluteTTL is time after which session expires even if it is used, zero means never.
+	AbsoluteTTL time.Duration
+	// MaxPerServer limits number of sessions of one server, zero means no limit.
+	MaxPerServer int
+	// FailOnUnhealthy keeps clients pinned to dead server instead of moving them to another one.
+	FailOnUnhealthy bool
+}
+
+var DefaultSessionPolicy = SessionPolicy{IdleTTL: DefaultSessionTTL}
+
+// SessionStore pins clients to servers of a pool. It is safe for concurrent use, sessions are
+// split between shards with separate locks, so requests of different clients rarely wait for
+// each other. Expired sessions are never returned, Sweep removes them from memory.
+type SessionStore struct {
+	policy SessionPolicy
+	shards [sessionShards]sessionShard
+	// counts holds number of sessions of every server of the pool, the map is never modified
+	// after creation, so it is read without locking
+	counts map[*Server]*atomic.Int64
+	now    func() time.Time
+}
+
+type sessionShard struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+type session struct {
+	server   *Server
+	created  time.Time
+	lastSeen time.Time
+}
+
+func NewSessionStore(servers []*Server, policy SessionPolicy) *SessionStore {
+	s := &SessionStore{
+		policy: policy,
+		counts: make(map[*Server]*atomic.Int64, len(servers)),
+		now:    time.Now,
+	}
+	for _, server := range servers {
+		s.counts[server] = atomic.NewInt64(0)
+	}
+	for i := range s.shards {
+		s.shards[i].sessions = make(map[string]session)
+	}
+	return s
+}
+
+func (s *SessionStore) shard(key string) *sessionShard {
+	return &s.shards[utils.Hash(key)%sessionShards]
+}
+
+func (s *SessionStore) Policy() SessionPolicy {
+	return s.policy
+}
+
+func (s *SessionStore) expired(session session, now time.Time) bool {
+	if s.policy.AbsoluteTTL > 0 && session.created.Add(s.policy.AbsoluteTTL).Before(now) {
+		return true
+	}
+	return session.lastSeen.Add(s.policy.IdleTTL).Before(now)
+}
+
+// abandoned tells whether session should be removed because its server is dead.
+func (s *SessionStore) abandoned(session session) bool {
+	return !s.policy.FailOnUnhealthy && !session.server.IsAlive()
+}
+
+// remove deletes session of key, shard must be locked.
+func (s *SessionStore) remove(shard *sessionShard, key string, session session) {
+	delete(shard.sessions, key)
+	s.counts[session.server].Dec()
+}
+
+// Get returns server the key is pinned to and refreshes the session. Expired sessions are removed
+// and nil is returned. Session of dead server is removed as well, unless policy says to fail, then
+// the dead server is returned and the session is not refreshed, so it expires if server does not
+// recover in time.
+func (s *SessionStore) Get(key string) *Server {
+	shard := s.shard(key)
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	session, ok := shard.sessions[key]
+	if !ok {
+		return nil
+	}
+	if s.expired(session, now) || s.abandoned(session) {
+		s.remove(shard, key, session)
+		return nil
+	}
+	if session.server.IsAlive() {
+		session.lastSeen = now
+		shard.sessions[key] = session
+	}
+	return session.server
+}
+
+// Add pins key to server, replacing previous session of the key, and tells whether it did so.
+// Servers which are not part of the pool and servers with maximum number of sessions are not pinned.
+func (s *SessionStore) Add(key string, server *Server) bool {
+	count, ok := s.counts[server]
+	if !ok {
+		return false
+	}
+	shard := s.shard(key)
+	now := s.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	previous, ok := shard.sessions[key]
+	if ok && previous.server == server {
+		shard.sessions[key] = session{server: server, created: now, lastSeen: now}
+		return true
+	}
+	// the limit may be exceeded slightly when keys of different shards are added concurrently
+	if s.policy.MaxPerServer > 0 && count.Load() >= int64(s.policy.MaxPerServer) {
+		return false
+	}
+	if ok {
+		s.counts[previous.server].Dec()
+	}
+	shard.sessions[key] = session{server: server, created: now, lastSeen: now}
+	count.Inc()
+	return true
+}
+
+// Count returns number of sessions pinned to server.
+func (s *SessionStore) Count(server *Server) int {
+	if count, ok := s.counts[server]; ok {
+		return int(count.Load())
+	}
+	return 0
+}
+
+// Sweep removes expired sessions and, unless policy says to fail, sessions of dead servers.
+// It returns how many sessions were removed. Shards are locked one at a time, so requests are
+// blocked only for a sweep of a single shard.
+func (s *SessionStore) Sweep() int {
+	removed := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		now := s.now()
+		shard.mu.Lock()
+		for key, session := range shard.sessions {
+			if s.expired(session, now) || s.abandoned(session) {
+				s.remove(shard, key, session)
+				removed++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return removed
+}
+
+// Clone returns store with a new set of servers and policy with the sessions of servers present
+// in both stores, so clients stay on their servers when the pool is reloaded.
+func (s *SessionStore) Clone(servers []*Server, policy SessionPolicy) *SessionStore {
+	clone := NewSessionStore(servers, policy)
+	clone.now = s.now
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for key, session := range shard.sessions {
+			if count, ok := clone.counts[session.server]; ok {
+				clone.shards[i].sessions[key] = session
+				count.Inc()
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return clone
+}
@@ -0,0 +1,300 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+func newSessionServers(n int) []*Server {
+	servers := make([]*Server, n)
+	for i := range servers {
+		serverUrl, _ := url.Parse(fmt.Sprintf("http://localhost:%d", 1111+i))
+		servers[i] = &Server{Url: serverUrl, Alive: atomic.NewBool(true), Weight: atomic.NewInt64(1)}
+	}
+	return servers
+}
+
+// setClock makes store read time from returned pointer.
+func setClock(store *SessionStore) *time.Time {
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	return &now
+}
+
+// sessionsLen returns number of all sessions of store, including expired ones which were not swept yet.
+func sessionsLen(store *SessionStore) int {
+	n := 0
+	for i := range store.shards {
+		shard := &store.shards[i]
+		shard.mu.Lock()
+		n += len(shard.sessions)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+func TestSessionStoreAdd(t *testing.T) {
+	servers := newSessionServers(2)
+	store := NewSessionStore(servers, DefaultSessionPolicy)
+
+	store.Add("127.0.0.1", servers[0])
+	store.Add("192.168.0.1", servers[0])
+	store.Add("192.168.0.1", servers[1])
+
+	if got := store.Get("127.0.0.1"); got != servers[0] {
+		t.Errorf("wrong server. got %v want %v", got, servers[0])
+	}
+	if got := store.Get("192.168.0.1"); got != servers[1] {
+		t.Errorf("wrong server. got %v want %v", got, servers[1])
+	}
+	if got := store.Count(servers[0]); got != 1 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 1)
+	}
+	if got := store.Count(servers[1]); got != 1 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 1)
+	}
+}
+
+func TestSessionStoreIgnoresUnknownServer(t *testing.T) {
+	servers := newSessionServers(2)
+	store := NewSessionStore(servers[:1], DefaultSessionPolicy)
+
+	store.Add("127.0.0.1", servers[1])
+
+	if got := store.Get("127.0.0.1"); got != nil {
+		t.Errorf("expected no server, got %v", got)
+	}
+	if got := sessionsLen(store); got != 0 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 0)
+	}
+}
+
+func TestSessionStoreExpiry(t *testing.T) {
+	servers := newSessionServers(1)
+	store := NewSessionStore(servers, SessionPolicy{IdleTTL: time.Minute})
+	now := setClock(store)
+
+	store.Add("127.0.0.1", servers[0])
+	store.Add("192.168.0.1", servers[0])
+	*now = now.Add(40 * time.Second)
+	// getting a session refreshes it
+	if got := store.Get("127.0.0.1"); got != servers[0] {
+		t.Errorf("wrong server. got %v want %v", got, servers[0])
+	}
+	*now = now.Add(40 * time.Second)
+
+	if got := store.Get("192.168.0.1"); got != nil {
+		t.Errorf("expected expired session, got %v", got)
+	}
+	if got := store.Get("127.0.0.1"); got != servers[0] {
+		t.Errorf("wrong server. got %v want %v", got, servers[0])
+	}
+	if got := store.Count(servers[0]); got != 1 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 1)
+	}
+}
+
+func TestSessionStoreDeadServer(t *testing.T) {
+	servers := newSessionServers(2)
+	store := NewSessionStore(servers, DefaultSessionPolicy)
+	store.Add("127.0.0.1", servers[0])
+
+	servers[0].SetAlive(false)
+
+	if got := store.Get("127.0.0.1"); got != nil {
+		t.Errorf("expected no server, got %v", got)
+	}
+	if got := store.Count(servers[0]); got != 0 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 0)
+	}
+}
+
+func TestSessionStoreSweep(t *testing.T) {
+	servers := newSessionServers(3)
+	store := NewSessionStore(servers, SessionPolicy{IdleTTL: time.Minute})
+	now := setClock(store)
+	store.Add("127.0.0.1", servers[0])
+	store.Add("192.168.0.1", servers[1])
+	*now = now.Add(30 * time.Second)
+	store.Add("10.0.0.1", servers[2])
+	store.Add("10.0.0.2", servers[2])
+
+	servers[1].SetAlive(false)
+	*now = now.Add(40 * time.Second)
+	removed := store.Sweep()
+
+	if removed != 2 {
+		t.Errorf("wrong number of removed sessions. got %d want %d", removed, 2)
+	}
+	if got := sessionsLen(store); got != 2 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 2)
+	}
+	for i, want := range []int{0, 0, 2} {
+		if got := store.Count(servers[i]); got != want {
+			t.Errorf("wrong number of sessions of server %d. got %d want %d", i, got, want)
+		}
+	}
+}
+
+func TestSessionStoreClone(t *testing.T) {
+	servers := newSessionServers(3)
+	store := NewSessionStore(servers[:2], DefaultSessionPolicy)
+	store.Add("127.0.0.1", servers[0])
+	store.Add("192.168.0.1", servers[1])
+
+	clone := store.Clone([]*Server{servers[1], servers[2]}, DefaultSessionPolicy)
+	clone.Add("10.0.0.1", servers[2])
+
+	if got := clone.Get("127.0.0.1"); got != nil {
+		t.Errorf("expected session of removed server to be dropped, got %v", got)
+	}
+	if got := clone.Get("192.168.0.1"); got != servers[1] {
+		t.Errorf("wrong server. got %v want %v", got, servers[1])
+	}
+	if got := clone.Count(servers[1]); got != 1 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 1)
+	}
+	if got := sessionsLen(store); got != 2 {
+		t.Errorf("original store modified, got %d sessions want %d", got, 2)
+	}
+}
+
+func TestSessionStoreConcurrentAccess(t *testing.T) {
+	servers := newSessionServers(4)
+	store := NewSessionStore(servers, DefaultSessionPolicy)
+	const goroutines, keys = 16, 200
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("10.0.%d.%d", g%4, i)
+				if store.Get(key) == nil {
+					store.Add(key, servers[(g+i)%len(servers)])
+				}
+				store.Count(servers[i%len(servers)])
+				if i%50 == 0 {
+					servers[g%len(servers)].SetAlive(i%100 != 0)
+					store.Sweep()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, server := range servers {
+		total += store.Count(server)
+	}
+	if total != sessionsLen(store) {
+		t.Errorf("session counts out of sync. sum of counts %d, sessions %d", total, sessionsLen(store))
+	}
+}
+
+func TestServerPoolStickySessionsConcurrentAccess(t *testing.T) {
+	servers := newSessionServers(3)
+	serverPool := &ServerPool{Servers: servers, Sessions: NewSessionStore(servers, DefaultSessionPolicy)}
+	strategy := &RoundRobin{}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				remoteAddr := fmt.Sprintf("10.0.0.%d", (g*500+i)%64)
+				first := strategy.GetServer(serverPool, remoteAddr)
+				if next := strategy.GetServer(serverPool, remoteAddr); next != first {
+					t.Errorf("client %s moved from %s to %s", remoteAddr, first.Url, next.Url)
+					return
+				}
+				if i%100 == 0 {
+					serverPool.SweepStickySessions()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if got := sessionsLen(serverPool.Sessions); got != 64 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 64)
+	}
+}
+
+func TestServerPoolWithoutSessions(t *testing.T) {
+	servers := newSessionServers(2)
+	serverPool := &ServerPool{Servers: servers}
+
+	serverPool.AddStickySession("127.0.0.1", servers[0])
+
+	if got := serverPool.GetServerFromStickySession("127.0.0.1"); got != nil {
+		t.Errorf("expected sticky sessions to be disabled, got %v", got)
+	}
+	if got := serverPool.NumberOfStickySessions(servers[0]); got != 0 {
+		t.Errorf("wrong number of sessions. got %d want %d", got, 0)
+	}
+}
+
+func TestSessionStoreAbsoluteTTL(t *testing.T) {
+	servers := newSessionServers(1)
+	store := NewSessionStore(servers, SessionPolicy{IdleTTL: time.Minute, AbsoluteTTL: 90 * time.Second})
+	now := setClock(store)
+	store.Add("127.0.0.1", servers[0])
+
+	*now = now.Add(50 * time.Second)
+	if got := store.Get("127.0.0.1"); got != servers[0] {
+		t.Errorf("wrong server. got %v want %v", got, servers[0])
+	}
+	*now = now.Add(50 * time.Second)
+
+	if got := store.Get("127.0.0.1"); got != nil {
+		t.Errorf("expected session to expire after absolute ttl, got %v", got)
+	}
+}
+
+func TestSessionStoreMaxPerServer(t *testing.T) {
+	servers := newSessionServers(2)
+	store := NewSessionStore(servers, SessionPolicy{IdleTTL: time.Minute, MaxPerServer: 2})
+
+	for i, want := range []bool{true, true, false} {
+		if got := store.Add(fmt.Sprintf("10.0.0.%d", i), servers[0]); got != want {
+			t.Errorf("wrong result of adding session %d. got %t want %t", i, got, want)
+		}
+	}
+	if !store.Add("10.0.0.0", servers[0]) {
+		t.Errorf("existing session of full server should be renewed")
+	}
+	if !store.Add("10.0.0.1", servers[1]) || store.Count(servers[0]) != 1 {
+		t.Errorf("moving session to another server should release the slot")
+	}
+}
+
+func TestSessionStoreFailOnUnhealthy(t *testing.T) {
+	servers := newSessionServers(2)
+	store := NewSessionStore(servers, SessionPolicy{IdleTTL: time.Minute, FailOnUnhealthy: true})
+	now := setClock(store)
+	store.Add("127.0.0.1", servers[0])
+
+	servers[0].SetAlive(false)
+	*now = now.Add(40 * time.Second)
+
+	if got := store.Get("127.0.0.1"); got != servers[0] {
+		t.Errorf("expected dead pinned server, got %v", got)
+	}
+	if removed := store.Sweep(); removed != 0 {
+		t.Errorf("session of dead server should be kept, removed %d", removed)
+	}
+
+	// requests to dead server do not refresh session
+	*now = now.Add(40 * time.Second)
+	if got := store.Get("127.0.0.1"); got != nil {
+		t.Errorf("expected session to expire, got %v", got)
+	}
+}
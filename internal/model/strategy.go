@@ -1,17 +1,85 @@
 package model
 
 import (
+	"net/http"
 	"slices"
+	"sync"
 
 	"github.com/ajablonsk1/gload-balancer/internal/utils"
 )
 
+// LoadDistributionStrategy picks server knowing only address of the client. Strategies which
+// need more of the request implement Strategy, Adapt turns the former into the latter.
 type LoadDistributionStrategy interface {
 	GetServer(serverPool *ServerPool, remoteAddr string) *Server
 }
 
+// Request is what Strategy knows about request it picks server for.
+type Request struct {
+	// HTTP is the proxied request, strategies must not modify it.
+	HTTP *http.Request
+	// ClientIP is address of client resolved behind trusted proxies.
+	ClientIP string
+	// Route is name of route which matched request, empty for listeners with own pool
+	// and requests sent to the default pool.
+	Route string
+	// Attempt counts tries of request, starting at 1.
+	Attempt int
+	// Exclude are servers which must not be picked, e.g. ones which already failed request.
+	Exclude []*Server
+}
+
+// Excluded tells if server must not be picked for request.
+func (r *Request) Excluded(server *Server) bool {
+	return slices.Contains(r.Exclude, server)
+}
+
+type Strategy interface {
+	Pick(serverPool *ServerPool, request *Request) *Server
+}
+
+// Adapt returns strategy itself when it implements Strategy, otherwise Strategy calling its
+// GetServer with client address of request.
+func Adapt(strategy LoadDistributionStrategy) Strategy {
+	if s, ok := strategy.(Strategy); ok {
+		return s
+	}
+	return adapter{strategy}
+}
+
+type adapter struct {
+	LoadDistributionStrategy
+}
+
+func (a adapter) Pick(serverPool *ServerPool, request *Request) *Server {
+	return pickWithGetServer(a.LoadDistributionStrategy, serverPool, request)
+}
+
+// pickWithGetServer asks strategy for server and, because strategy knows nothing about excluded
+// servers, takes the next alive one which is not excluded when it returns excluded server.
+func pickWithGetServer(strategy LoadDistributionStrategy, serverPool *ServerPool, request *Request) *Server {
+	server := strategy.GetServer(serverPool, request.ClientIP)
+	if server == nil || !request.Excluded(server) {
+		return server
+	}
+
+	idx := slices.Index(serverPool.Servers, server)
+	serversLength := len(serverPool.Servers)
+	for i := idx + 1; i <= idx+serversLength; i++ {
+		server := serverPool.Servers[i%serversLength]
+		if server.IsAlive() && !request.Excluded(server) {
+			return server
+		}
+	}
+	return nil
+}
+
 type RoundRobin struct{}
 
+func (r *RoundRobin) Pick(serverPool *ServerPool, request *Request) *Server {
+	return pickWithGetServer(r, serverPool, request)
+}
+
 func (r *RoundRobin) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
 	if s := serverPool.GetServerFromStickySession(remoteAddr); s != nil {
 		return s
@@ -30,52 +98,72 @@ func (r *RoundRobin) GetServer(serverPool *ServerPool, remoteAddr string) *Serve
 			if i != next {
 				serverPool.CurrentIdx.Store(uint64(i))
 			}
-			server.AddStickySession(remoteAddr)
+			serverPool.AddStickySession(remoteAddr, server)
 			return server
 		}
 	}
 	return nil
 }
 
+// WeightedRoundRobin is smooth weighted round robin, as in nginx. On every pick each alive server
+// adds its weight to its current weight, the server with the highest current weight is picked and
+// its current weight is lowered by the sum of weights. Picks of servers are interleaved, e.g.
+// weights 5, 1, 1 give a, a, b, a, c, a, a, and dead servers do not skew ratios of the others.
 type WeightedRoundRobin struct {
-	sentReqToSameServer int
+	mu sync.Mutex
+	// pool current weights belong to, they are reset when server pool is replaced
+	pool    *ServerPool
+	current map[*Server]int
 }
 
-func (wR *WeightedRoundRobin) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
-	if s := serverPool.GetServerFromStickySession(remoteAddr); s != nil {
+func (wR *WeightedRoundRobin) Pick(serverPool *ServerPool, request *Request) *Server {
+	if s := serverPool.GetServerFromStickySession(request.ClientIP); s != nil && !request.Excluded(s) {
 		return s
 	}
 
-	currServer := serverPool.Servers[serverPool.GetCurrentIdx()]
-	// if current server is alive and we didn't send him enough requests, we return this server and add one to sent variable
-	if wR.sentReqToSameServer < currServer.Weight && currServer.IsAlive() {
-		wR.sentReqToSameServer = wR.sentReqToSameServer + 1
-		currServer.AddStickySession(remoteAddr)
-		return currServer
+	server := wR.next(serverPool, request)
+	if server != nil {
+		serverPool.AddStickySession(request.ClientIP, server)
 	}
+	return server
+}
 
-	// if current server got enough requests or is dead we get next server
-	// servers are sorted by weight so code will be simmilar to normal round robin
-	wR.sentReqToSameServer = 0
-	next := serverPool.NextIndex()
-	serversLength := len(serverPool.Servers)
-	fullCycleLength := serversLength + next
-	for i := next; i < fullCycleLength; i++ {
-		idx := i % serversLength
-		server := serverPool.Servers[idx]
-		if server.IsAlive() {
-			if i != next {
-				serverPool.CurrentIdx.Store(uint64(i))
-			}
-			server.AddStickySession(remoteAddr)
-			return server
+func (wR *WeightedRoundRobin) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
+	return wR.Pick(serverPool, &Request{ClientIP: remoteAddr})
+}
+
+func (wR *WeightedRoundRobin) next(serverPool *ServerPool, request *Request) *Server {
+	wR.mu.Lock()
+	defer wR.mu.Unlock()
+	if wR.pool != serverPool {
+		wR.pool = serverPool
+		wR.current = make(map[*Server]int, len(serverPool.Servers))
+	}
+
+	var best *Server
+	total := 0
+	for _, server := range serverPool.Servers {
+		if !server.IsAlive() || request.Excluded(server) {
+			continue
+		}
+		wR.current[server] += server.GetWeight()
+		total += server.GetWeight()
+		if best == nil || wR.current[server] > wR.current[best] {
+			best = server
 		}
 	}
-	return nil
+	if best != nil {
+		wR.current[best] -= total
+	}
+	return best
 }
 
 type IPHash struct{}
 
+func (i *IPHash) Pick(serverPool *ServerPool, request *Request) *Server {
+	return pickWithGetServer(i, serverPool, request)
+}
+
 func (i *IPHash) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
 	if s := serverPool.GetServerFromStickySession(remoteAddr); s != nil {
 		return s
@@ -92,60 +180,79 @@ func (i *IPHash) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
 		idx := i % serversLength
 		server := serverPool.Servers[idx]
 		if server.IsAlive() {
-			server.AddStickySession(remoteAddr)
+			serverPool.AddStickySession(remoteAddr, server)
 			return server
 		}
 	}
 	return nil
 }
 
-type LeastSession struct{}
+// LeastSession picks alive server with the least requests in flight or open connections, ties
+// are broken in turns.
+type LeastSession struct {
+	// By is LoadRequests or LoadConnections, empty means LoadRequests.
+	By string
+}
+
+func (l *LeastSession) Pick(serverPool *ServerPool, request *Request) *Server {
+	return pickWithGetServer(l, serverPool, request)
+}
 
 func (l *LeastSession) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
 	if s := serverPool.GetServerFromStickySession(remoteAddr); s != nil {
 		return s
 	}
 
-	slices.SortFunc(serverPool.Servers, SortByNConnections)
-	serversLength := len(serverPool.Servers)
-	for i := 0; i < serversLength; i++ {
-		server := serverPool.Servers[i]
-		if server.IsAlive() {
-			server.AddStickySession(remoteAddr)
-			return server
-		}
+	server := leastLoaded(serverPool, nil, func(a, b *Server) bool {
+		return a.Load(l.By) < b.Load(l.By)
+	})
+	if server != nil {
+		serverPool.AddStickySession(remoteAddr, server)
 	}
-	return nil
+	return server
 }
 
-type WeightedLeastSession struct{}
+// WeightedLeastSession picks alive server with the least requests in flight or open connections
+// relative to its weight, so that without load the heaviest server is picked.
+type WeightedLeastSession struct {
+	// By is LoadRequests or LoadConnections, empty means LoadRequests.
+	By string
+}
+
+func (wL *WeightedLeastSession) Pick(serverPool *ServerPool, request *Request) *Server {
+	return pickWithGetServer(wL, serverPool, request)
+}
 
 func (wL *WeightedLeastSession) GetServer(serverPool *ServerPool, remoteAddr string) *Server {
 	if s := serverPool.GetServerFromStickySession(remoteAddr); s != nil {
 		return s
 	}
 
-	slices.SortFunc(serverPool.Servers, SortByNConnections)
+	// the request being picked for is counted in
+	server := leastLoaded(serverPool, nil, func(a, b *Server) bool {
+		return (a.Load(wL.By)+1)*int64(b.GetWeight()) < (b.Load(wL.By)+1)*int64(a.GetWeight())
+	})
+	if server != nil {
+		serverPool.AddStickySession(remoteAddr, server)
+	}
+	return server
+}
+
+// leastLoaded returns alive server which is not excluded and than which no other is less loaded.
+// Servers are scanned from the next index of pool, so that equally loaded servers take turns.
+// Unlike sorting, the scan does not reorder servers under other requests.
+func leastLoaded(serverPool *ServerPool, exclude []*Server, less func(a, b *Server) bool) *Server {
 	serversLength := len(serverPool.Servers)
-	serversGroupedByNConnections := make([][]*Server, 0)
-	lastNConnections := -1
-	for i := 0; i < serversLength; i++ {
-		server := serverPool.Servers[i]
-		if server.IsAlive() {
-			if lastNConnections != server.NumberOfStickySessions() {
-				serversGroupedByNConnections = append(serversGroupedByNConnections, make([]*Server, 0))
-				lastNConnections = server.NumberOfStickySessions()
-			}
-			serversGroupedByNConnections[len(serversGroupedByNConnections)-1] = append(serversGroupedByNConnections[len(serversGroupedByNConnections)-1], server)
-		}
+	if serversLength == 0 {
+		return nil
 	}
-	for _, servers := range serversGroupedByNConnections {
-		if len(servers) > 0 {
-			slices.SortFunc(servers, SortByWeight)
-			server := servers[0]
-			server.AddStickySession(remoteAddr)
-			return server
+	next := serverPool.NextIndex()
+	var least *Server
+	for i := 0; i < serversLength; i++ {
+		server := serverPool.Servers[(next+i)%serversLength]
+		if server.IsAlive() && !slices.Contains(exclude, server) && (least == nil || less(server, least)) {
+			least = server
 		}
 	}
-	return nil
+	return least
 }
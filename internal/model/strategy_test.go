@@ -1,8 +1,13 @@
 package model_test
 
 import (
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 
+	"go.uber.org/atomic"
+
 	"github.com/ajablonsk1/gload-balancer/internal/config"
 	"github.com/ajablonsk1/gload-balancer/internal/model"
 	"github.com/ajablonsk1/gload-balancer/internal/utils"
@@ -101,6 +106,90 @@ func TestWeightedRoundRobinGetServerStickySession(t *testing.T) {
 	})
 }
 
+// newWeightedServerPool returns server pool without sticky sessions with servers a, b, c, ...
+// of given weights.
+func newWeightedServerPool(weights ...int) *model.ServerPool {
+	serverPool := &model.ServerPool{}
+	for i, weight := range weights {
+		serverUrl, _ := url.Parse("http://" + string(rune('a'+i)))
+		serverPool.Servers = append(serverPool.Servers, &model.Server{Url: serverUrl, Alive: atomic.NewBool(true), Weight: atomic.NewInt64(int64(weight))})
+	}
+	return serverPool
+}
+
+func TestSmoothWeightedRoundRobin(t *testing.T) {
+	t.Run("interleaves picks", func(t *testing.T) {
+		serverPool := newWeightedServerPool(5, 1, 1)
+		strategy := model.WeightedRoundRobin{}
+
+		var got []string
+		for i := 0; i < 14; i++ {
+			got = append(got, strategy.Pick(serverPool, &model.Request{}).Url.Host)
+		}
+
+		want := "a a b a c a a a a b a c a a"
+		if strings.Join(got, " ") != want {
+			t.Errorf("wrong order. got %s want %s", strings.Join(got, " "), want)
+		}
+	})
+
+	t.Run("does not require servers sorted by weight", func(t *testing.T) {
+		serverPool := newWeightedServerPool(1, 1, 5)
+		strategy := model.WeightedRoundRobin{}
+
+		var got []string
+		for i := 0; i < 7; i++ {
+			got = append(got, strategy.Pick(serverPool, &model.Request{}).Url.Host)
+		}
+
+		want := "c c a c b c c"
+		if strings.Join(got, " ") != want {
+			t.Errorf("wrong order. got %s want %s", strings.Join(got, " "), want)
+		}
+	})
+
+	t.Run("keeps ratios of alive servers", func(t *testing.T) {
+		serverPool := newWeightedServerPool(5, 1, 1)
+		serverPool.Servers[2].SetAlive(false)
+		strategy := model.WeightedRoundRobin{}
+
+		counts := make(map[string]int)
+		for i := 0; i < 60; i++ {
+			counts[strategy.Pick(serverPool, &model.Request{}).Url.Host]++
+		}
+
+		if counts["a"] != 50 || counts["b"] != 10 || counts["c"] != 0 {
+			t.Errorf("wrong picks. got %v want a: 50, b: 10", counts)
+		}
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		serverPool := newWeightedServerPool(5, 1, 1)
+		strategy := model.WeightedRoundRobin{}
+
+		var mu sync.Mutex
+		counts := make(map[string]int)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 70; j++ {
+					server := strategy.Pick(serverPool, &model.Request{})
+					mu.Lock()
+					counts[server.Url.Host]++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if counts["a"] != 500 || counts["b"] != 100 || counts["c"] != 100 {
+			t.Errorf("wrong picks. got %v want a: 500, b: 100, c: 100", counts)
+		}
+	})
+}
+
 func TestIpHashGetServer(t *testing.T) {
 	t.Run("get proper server from server pool weighted round robin", func(t *testing.T) {
 		c, _ := config.GetConfig("../../config/config.json")
@@ -138,11 +227,48 @@ func TestLeastSessionsGetServer(t *testing.T) {
 		c, _ := config.GetConfig("../../config/config.json")
 		serverPool, _ := c.GetServerPool()
 		strategy := model.LeastSession{}
+		serverPool.Servers[0].InFlight.Store(2)
+		serverPool.Servers[1].InFlight.Store(1)
 
-		_ = strategy.GetServer(serverPool, "localhost:22222")
-		_ = strategy.GetServer(serverPool, "localhost:22223")
-		_ = strategy.GetServer(serverPool, "localhost:22224")
-		next := strategy.GetServer(serverPool, "localhost:22225")
+		next := strategy.GetServer(serverPool, "localhost:22222")
+
+		got := next.Url.String()
+		want := "http://localhost:1112"
+		if got != want {
+			t.Errorf("wrong server. got %s want %s", got, want)
+		}
+
+		serverPool.Servers[1].InFlight.Store(3)
+		next = strategy.GetServer(serverPool, "localhost:22223")
+
+		got = next.Url.String()
+		want = "http://localhost:1111"
+		if got != want {
+			t.Errorf("wrong server. got %s want %s", got, want)
+		}
+	})
+
+	t.Run("takes turns between equally loaded servers", func(t *testing.T) {
+		c, _ := config.GetConfig("../../config/config.json")
+		serverPool, _ := c.GetServerPool()
+		strategy := model.LeastSession{}
+
+		first := strategy.GetServer(serverPool, "localhost:22222")
+		second := strategy.GetServer(serverPool, "localhost:22223")
+
+		if first == second {
+			t.Errorf("wrong server. got %s twice", first.Url)
+		}
+	})
+
+	t.Run("counts open connections", func(t *testing.T) {
+		c, _ := config.GetConfig("../../config/config.json")
+		serverPool, _ := c.GetServerPool()
+		strategy := model.LeastSession{By: model.LoadConnections}
+		serverPool.Servers[0].InFlight.Store(5)
+		serverPool.Servers[1].Connections.Store(2)
+
+		next := strategy.GetServer(serverPool, "localhost:22222")
 
 		got := next.Url.String()
 		want := "http://localhost:1111"
@@ -177,17 +303,22 @@ func TestLeastSessionsGetServerStickySessionExtended(t *testing.T) {
 		serverPool, _ := c.GetServerPool()
 		strategy := model.LeastSession{}
 
-		_ = strategy.GetServer(serverPool, "localhost:22222")
+		s := strategy.GetServer(serverPool, "localhost:22222")
+		// pinned client stays on its server even when it is the most loaded one
+		s.InFlight.Store(10)
 		_ = strategy.GetServer(serverPool, "localhost:22223")
-		_ = strategy.GetServer(serverPool, "localhost:22222")
-		_ = strategy.GetServer(serverPool, "localhost:22224")
-		next := strategy.GetServer(serverPool, "localhost:22225")
+		next := strategy.GetServer(serverPool, "localhost:22222")
 
 		got := next.Url.String()
-		want := "http://localhost:1111"
+		want := s.Url.String()
 		if got != want {
 			t.Errorf("wrong server. got %s want %s", got, want)
 		}
+
+		next = strategy.GetServer(serverPool, "localhost:22224")
+		if next == s {
+			t.Errorf("wrong server. got the most loaded %s", s.Url)
+		}
 	})
 }
 
@@ -196,20 +327,20 @@ func TestLeastSessionsGetServerStickySessionFailover(t *testing.T) {
 		c, _ := config.GetConfig("../../config/config.json")
 		serverPool, _ := c.GetServerPool()
 		strategy := model.LeastSession{}
+		serverPool.Servers[1].InFlight.Store(1)
 
 		s := strategy.GetServer(serverPool, "localhost:22222")
 		_ = strategy.GetServer(serverPool, "localhost:22223")
-		_ = strategy.GetServer(serverPool, "localhost:22222")
-		_ = strategy.GetServer(serverPool, "localhost:22224")
 		s.SetAlive(false)
-		next := strategy.GetServer(serverPool, "localhost:22225")
+		next := strategy.GetServer(serverPool, "localhost:22222")
 
 		got := next.Url.String()
 		want := "http://localhost:1112"
 		if got != want {
 			t.Errorf("wrong server. got %s want %s", got, want)
 		}
-		if s.NumberOfStickySessions() != 0 && len(s.StickySessions) != 0 {
+		serverPool.SweepStickySessions()
+		if serverPool.NumberOfStickySessions(s) != 0 {
 			t.Errorf("sticky session not cleared")
 		}
 	})
@@ -238,12 +369,9 @@ func TestWeightedLeastSessionsGetServerExtended(t *testing.T) {
 		c, _ := config.GetConfig("../../config/config.json")
 		serverPool, _ := c.GetServerPool()
 		strategy := model.WeightedLeastSession{}
+		// weights 3 and 2, (2+1)/3 > (0+1)/2
+		serverPool.Servers[0].InFlight.Store(2)
 
-		_ = strategy.GetServer(serverPool, "localhost:22222")
-		_ = strategy.GetServer(serverPool, "localhost:22223")
-		_ = strategy.GetServer(serverPool, "localhost:22224")
-		_ = strategy.GetServer(serverPool, "localhost:22225")
-		_ = strategy.GetServer(serverPool, "localhost:22226")
 		next := strategy.GetServer(serverPool, "localhost:22227")
 
 		got := next.Url.String()
@@ -251,6 +379,16 @@ func TestWeightedLeastSessionsGetServerExtended(t *testing.T) {
 		if got != want {
 			t.Errorf("wrong server. got %s want %s", got, want)
 		}
+
+		// (2+1)/3 < (2+1)/2
+		serverPool.Servers[1].InFlight.Store(2)
+		next = strategy.GetServer(serverPool, "localhost:22228")
+
+		got = next.Url.String()
+		want = "http://localhost:1111"
+		if got != want {
+			t.Errorf("wrong server. got %s want %s", got, want)
+		}
 	})
 }
 
@@ -260,17 +398,59 @@ func TestWeightedLeastSessionsGetServerStickySession(t *testing.T) {
 		serverPool, _ := c.GetServerPool()
 		strategy := model.WeightedLeastSession{}
 
-		_ = strategy.GetServer(serverPool, "localhost:22222")
-		_ = strategy.GetServer(serverPool, "localhost:22223")
-		_ = strategy.GetServer(serverPool, "localhost:22224")
-		_ = strategy.GetServer(serverPool, "localhost:22225")
-		_ = strategy.GetServer(serverPool, "localhost:22222")
-		next := strategy.GetServer(serverPool, "localhost:22227")
+		s := strategy.GetServer(serverPool, "localhost:22222")
+		s.InFlight.Store(10)
+		next := strategy.GetServer(serverPool, "localhost:22222")
 
 		got := next.Url.String()
-		want := "http://localhost:1111"
+		want := s.Url.String()
 		if got != want {
 			t.Errorf("wrong server. got %s want %s", got, want)
 		}
 	})
 }
+
+// firstServer is a strategy which knows only GetServer.
+type firstServer struct{}
+
+func (f firstServer) GetServer(serverPool *model.ServerPool, remoteAddr string) *model.Server {
+	return serverPool.Servers[0]
+}
+
+func TestPickExcludesServers(t *testing.T) {
+	strategies := map[string]model.Strategy{
+		"round robin":             &model.RoundRobin{},
+		"weighted round robin":    &model.WeightedRoundRobin{},
+		"ip hash":                 &model.IPHash{},
+		"least sessions":          &model.LeastSession{},
+		"weighted least sessions": &model.WeightedLeastSession{},
+		"adapted GetServer":       model.Adapt(firstServer{}),
+	}
+
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			c, _ := config.GetConfig("../../config/config.json")
+			serverPool, _ := c.GetServerPool()
+			excluded := serverPool.Servers[0]
+
+			for i := 0; i < 5; i++ {
+				request := &model.Request{ClientIP: "192.0.2.1", Attempt: 2, Exclude: []*model.Server{excluded}}
+				if got := strategy.Pick(serverPool, request); got == nil || got == excluded {
+					t.Fatalf("wrong server. got %v want other than %s", got, excluded.Url)
+				}
+			}
+
+			request := &model.Request{ClientIP: "192.0.2.1", Exclude: serverPool.Servers}
+			if got := strategy.Pick(serverPool, request); got != nil {
+				t.Errorf("wrong server. got %s want nil when all servers are excluded", got.Url)
+			}
+		})
+	}
+
+	t.Run("returns strategy implementing Pick unchanged", func(t *testing.T) {
+		strategy := &model.RoundRobin{}
+		if got := model.Adapt(strategy); got != strategy {
+			t.Errorf("wrong strategy. got %T want %T", got, strategy)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// tableCache keeps lookup table which strategy builds from servers of a pool. Server pools are
+// replaced, never modified, on reload, so the table is built again for every new pool and when
+// version given by strategy changes, e.g. health version for tables of alive servers only.
+// Requests arriving during a build wait for it instead of building the same table.
+type tableCache[T any] struct {
+	current atomic.Pointer[cachedTable[T]]
+	mu      sync.Mutex
+}
+
+type cachedTable[T any] struct {
+	pool    *ServerPool
+	version uint64
+	table   T
+}
+
+// get returns table of serverPool for version. build gets table of the same pool built for
+// previous version, or zero value, so it can reuse what did not change.
+func (c *tableCache[T]) get(serverPool *ServerPool, version uint64, build func(previous T) T) T {
+	if cached := c.current.Load(); cached != nil && cached.pool == serverPool && cached.version == version {
+		return cached.table
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached := c.current.Load()
+	if cached != nil && cached.pool == serverPool && cached.version == version {
+		return cached.table
+	}
+
+	var previous T
+	if cached != nil && cached.pool == serverPool {
+		previous = cached.table
+	}
+	table := build(previous)
+	c.current.Store(&cachedTable[T]{pool: serverPool, version: version, table: table})
+	return table
+}
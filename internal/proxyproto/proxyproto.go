@@ -0,0 +1,248 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// v1MaxLength is the maximum length of version 1 header including CRLF.
+	v1MaxLength    = 107
+	v2HeaderLength = 16
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrNoHeader = errors.New("proxy protocol: missing header")
+
+// Listener decodes PROXY protocol header (version 1 or 2), which L4 load balancers in front of it
+// send to pass the real client address, of connections accepted from trusted sources.
+// Connections from other sources are returned untouched. Headers are read lazily, on first
+// Read or RemoteAddr of the connection, so a slow client does not block Accept.
+type Listener struct {
+	net.Listener
+	// Trusted are sources which must send the header, empty means none, as clients able to send
+	// the header could pretend to have any address.
+	Trusted []netip.Prefix
+	// HeaderTimeout limits time of reading the header, zero means no limit.
+	HeaderTimeout time.Duration
+}
+
+func NewListener(listener net.Listener, trusted []netip.Prefix, headerTimeout time.Duration) *Listener {
+	return &Listener{
+		Listener:      listener,
+		Trusted:       trusted,
+		HeaderTimeout: headerTimeout,
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{
+		Conn:          conn,
+		reader:        bufio.NewReaderSize(conn, v1MaxLength+1),
+		headerTimeout: l.HeaderTimeout,
+	}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, prefix := range l.Trusted {
+		if prefix.Contains(addrPort.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection which starts with PROXY protocol header.
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once sync.Once
+	// source and destination are nil when header does not carry addresses,
+	// e.g. for health checks of the load balancer
+	source      net.Addr
+	destination net.Addr
+	err         error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.headerTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.source, c.destination, c.err = ReadHeader(c.reader)
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns client address from the header, or address of the connection when header
+// does not carry one.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.destination != nil {
+		return c.destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader reads header of version 1 or 2 and returns addresses it carries. Addresses are nil
+// for UNKNOWN connections of version 1 and LOCAL commands or unsupported families of version 2.
+func ReadHeader(reader *bufio.Reader) (source net.Addr, destination net.Addr, err error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: reading header: %w", err)
+	}
+	switch first[0] {
+	case 'P':
+		return readV1(reader)
+	case v2Signature[0]:
+		return readV2(reader)
+	}
+	return nil, nil, ErrNoHeader
+}
+
+// readV1 reads text header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	line, err := reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > v1MaxLength {
+		return nil, nil, fmt.Errorf("proxy protocol: v1 header longer than %d bytes", v1MaxLength)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: reading v1 header: %w", err)
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("proxy protocol: v1 header must end with CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" {
+		return nil, nil, ErrNoHeader
+	}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("proxy protocol: invalid v1 header %q", line)
+	}
+
+	source, err := parseV1Address(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := parseV1Address(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, destination, nil
+}
+
+func parseV1Address(protocol string, ip string, port string) (net.Addr, error) {
+	if protocol != "TCP4" && protocol != "TCP6" {
+		return nil, fmt.Errorf("proxy protocol: unknown v1 protocol %q", protocol)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: invalid v1 address %q", ip)
+	}
+	if (protocol == "TCP4" && !addr.Is4()) || (protocol == "TCP6" && !addr.Is6()) {
+		return nil, fmt.Errorf("proxy protocol: address %s does not match %s", ip, protocol)
+	}
+	// leading zeros are not allowed
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("proxy protocol: invalid v1 port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readV2 reads binary header.
+func readV2(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: reading v2 header: %w", err)
+	}
+	if !bytes.Equal(header[:len(v2Signature)], v2Signature) {
+		return nil, nil, ErrNoHeader
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("proxy protocol: unsupported version %d", version)
+	}
+	command := header[12] & 0x0f
+	if command > 1 {
+		return nil, nil, fmt.Errorf("proxy protocol: unknown v2 command %d", command)
+	}
+	family, transport := header[13]>>4, header[13]&0x0f
+
+	// addresses are followed by TLVs, which are skipped
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, fmt.Errorf("proxy protocol: reading v2 addresses: %w", err)
+	}
+
+	// LOCAL command is sent by the load balancer itself, e.g. in health checks
+	if command == 0 || transport != 1 {
+		return nil, nil, nil
+	}
+
+	var size int
+	switch family {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// unspecified and unix sockets keep address of the connection
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, fmt.Errorf("proxy protocol: v2 address block too short")
+	}
+	sourceIP, _ := netip.AddrFromSlice(payload[:size])
+	destinationIP, _ := netip.AddrFromSlice(payload[size : 2*size])
+	sourcePort := binary.BigEndian.Uint16(payload[2*size:])
+	destinationPort := binary.BigEndian.Uint16(payload[2*size+2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(sourceIP, sourcePort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(destinationIP, destinationPort)), nil
+}
@@ -0,0 +1,155 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func v2Header(command byte, family byte, addresses []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20|command, family<<4|1, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	tests := []struct {
+		name        string
+		header      string
+		source      string
+		destination string
+		err         string
+	}{
+		{name: "v1 tcp4", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", source: "192.0.2.1:56324", destination: "198.51.100.1:443"},
+		{name: "v1 tcp6", header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", source: "[2001:db8::1]:56324", destination: "[2001:db8::2]:443"},
+		{name: "v1 unknown", header: "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"},
+		{name: "v1 family mismatch", header: "PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n", err: "does not match TCP4"},
+		{name: "v1 invalid port", header: "PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n", err: "invalid v1 port"},
+		{name: "v1 missing crlf", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", err: "must end with CRLF"},
+		{name: "v1 too long", header: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", err: "longer than 107 bytes"},
+		{name: "v2 ipv4", header: string(v2Header(1, 1, ipv4)), source: "192.0.2.1:56324", destination: "198.51.100.1:443"},
+		{name: "v2 with tlv", header: string(v2Header(1, 1, append(ipv4, 0x04, 0, 1, 0))), source: "192.0.2.1:56324", destination: "198.51.100.1:443"},
+		{name: "v2 local", header: string(v2Header(0, 0, nil))},
+		{name: "v2 short addresses", header: string(v2Header(1, 2, ipv4)), err: "address block too short"},
+		{name: "missing header", header: "GET / HTTP/1.1\r\n", err: ErrNoHeader.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(test.header+"GET / HTTP/1.1\r\n"), v1MaxLength+1)
+
+			source, destination, err := ReadHeader(reader)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("wrong error. got %v want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := addrString(source); got != test.source {
+				t.Errorf("wrong source. got %s want %s", got, test.source)
+			}
+			if got := addrString(destination); got != test.destination {
+				t.Errorf("wrong destination. got %s want %s", got, test.destination)
+			}
+			if rest, _ := reader.ReadString('\n'); rest != "GET / HTTP/1.1\r\n" {
+				t.Errorf("header was not consumed exactly, rest %q", rest)
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+// accept returns connection accepted by proxy protocol listener after client wrote data.
+func accept(t *testing.T, trusted []netip.Prefix, timeout time.Duration, data string) (net.Conn, net.Conn) {
+	t.Helper()
+	netListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := NewListener(netListener, trusted, timeout)
+	t.Cleanup(func() { listener.Close() })
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	io.WriteString(client, data)
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, client
+}
+
+func TestListener(t *testing.T) {
+	t.Run("trusted source", func(t *testing.T) {
+		conn, _ := accept(t, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, time.Second,
+			"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello")
+
+		if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+			t.Errorf("wrong remote address. got %s want %s", got, "192.0.2.1:56324")
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("wrong data after header. got %q, error %v", buf, err)
+		}
+	})
+
+	t.Run("untrusted source", func(t *testing.T) {
+		conn, _ := accept(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, time.Second,
+			"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+
+		if got := conn.RemoteAddr().String(); strings.HasPrefix(got, "192.0.2.1") {
+			t.Errorf("header of untrusted source was decoded, remote address %s", got)
+		}
+	})
+
+	t.Run("no trusted sources", func(t *testing.T) {
+		conn, _ := accept(t, nil, time.Second, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+
+		if got := conn.RemoteAddr().String(); strings.HasPrefix(got, "192.0.2.1") {
+			t.Errorf("header was decoded without trusted sources, remote address %s", got)
+		}
+	})
+
+	t.Run("trusted source without header", func(t *testing.T) {
+		conn, _ := accept(t, loopback, time.Second, "GET / HTTP/1.1\r\n")
+
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrNoHeader) {
+			t.Errorf("wrong error. got %v want %v", err, ErrNoHeader)
+		}
+	})
+
+	t.Run("header timeout", func(t *testing.T) {
+		conn, _ := accept(t, loopback, 50*time.Millisecond, "PROXY TCP4")
+
+		_, err := conn.Read(make([]byte, 1))
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("expected timeout, got %v", err)
+		}
+	})
+}
@@ -7,3 +7,17 @@ func Hash(s string) uint32 {
 	h.Write([]byte(s))
 	return h.Sum32()
 }
+
+// Hash64 returns FNV-1a hash of s with bits mixed by splitmix64 finalizer, so that similar
+// strings, e.g. names of virtual nodes, spread evenly over the whole range.
+func Hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+type stamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// FileWatcher detects changes of files by polling their modification time and size.
+// Polling is used instead of filesystem notifications, because editors and config management
+// tools often replace files by renaming, which breaks watches on the original inode.
+type FileWatcher struct {
+	paths  []string
+	stamps map[string]stamp
+}
+
+func NewFileWatcher(paths ...string) *FileWatcher {
+	w := &FileWatcher{
+		paths:  paths,
+		stamps: make(map[string]stamp),
+	}
+	for _, path := range paths {
+		w.stamps[path] = stat(path)
+	}
+	return w
+}
+
+// Changed reports whether any of watched files changed since the previous call.
+func (w *FileWatcher) Changed() bool {
+	changed := false
+	for _, path := range w.paths {
+		current := stat(path)
+		if current != w.stamps[path] {
+			w.stamps[path] = current
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Watch calls onChange every time any of watched files changes. It blocks until ctx is done.
+func (w *FileWatcher) Watch(ctx context.Context, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.Changed() {
+				onChange()
+			}
+		}
+	}
+}
+
+func stat(path string) stamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		exists:  true,
+	}
+}
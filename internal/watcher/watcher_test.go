@@ -0,0 +1,69 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcherChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := NewFileWatcher(path)
+
+	if w.Changed() {
+		t.Errorf("expected no change before file was modified")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"address": ""}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Errorf("expected change after file was modified")
+	}
+	if w.Changed() {
+		t.Errorf("expected change to be reported only once")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed() {
+		t.Errorf("expected change after file was removed")
+	}
+}
+
+func TestFileWatcherWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	w := NewFileWatcher(path)
+	changes := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		w.Watch(ctx, 10*time.Millisecond, func() {
+			changes <- struct{}{}
+		})
+		close(stopped)
+	}()
+
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Errorf("change was not reported")
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("watch did not stop")
+	}
+}
@@ -0,0 +1,121 @@
+package load_balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ajablonsk1/gload-balancer/internal/certificates"
+	c "github.com/ajablonsk1/gload-balancer/internal/config"
+	"github.com/ajablonsk1/gload-balancer/internal/handler"
+	"github.com/ajablonsk1/gload-balancer/internal/proxyproto"
+)
+
+const certificatesWatchInterval = 10 * time.Second
+
+type Listener struct {
+	Name     string
+	Addr     string
+	Protocol string
+	Handler  http.Handler
+	// ProxyHandler is the handler of listener's own pool. It is nil for listeners using router
+	// and for redirect listeners.
+	ProxyHandler *handler.ProxyHandler
+	// TLSConfig is set when listener terminates TLS.
+	TLSConfig *tls.Config
+	// ProxyProtocol is set when listener decodes PROXY protocol header.
+	ProxyProtocol *proxyproto.Listener
+
+	certificates *certificates.Store
+	server       *http.Server
+}
+
+func newListener(config c.ListenerConfig, all []c.ListenerConfig, router *handler.Router, options upstreamOptions) (*Listener, error) {
+	listener := &Listener{
+		Name:     config.Name,
+		Addr:     config.Address,
+		Protocol: config.Protocol,
+	}
+
+	switch {
+	case config.Protocol == c.ProtocolRedirect:
+		port, err := redirectPort(config.RedirectTo, all)
+		if err != nil {
+			return nil, err
+		}
+		listener.Handler = &handler.RedirectHandler{Port: port}
+	case len(config.Servers) > 0:
+		proxyHandler, err := newProxyHandler("listener "+config.Name, &config.PoolConfig, options)
+		if err != nil {
+			return nil, err
+		}
+		listener.ProxyHandler = proxyHandler
+		listener.Handler = proxyHandler
+	default:
+		listener.Handler = router
+	}
+
+	if config.TLS != nil {
+		var err error
+		listener.TLSConfig, listener.certificates, err = config.TLS.GetTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	if config.ProxyProtocol != nil {
+		trusted, err := config.ProxyProtocol.GetTrustedSources()
+		if err != nil {
+			return nil, fmt.Errorf("proxy_protocol: %w", err)
+		}
+		// the listener to wrap is set in serve
+		listener.ProxyProtocol = proxyproto.NewListener(nil, trusted, time.Duration(config.ProxyProtocol.HeaderTimeout))
+	}
+
+	listener.server = &http.Server{
+		Addr:      listener.Addr,
+		Handler:   listener.Handler,
+		TLSConfig: listener.TLSConfig,
+	}
+	return listener, nil
+}
+
+func redirectPort(name string, all []c.ListenerConfig) (string, error) {
+	for _, listener := range all {
+		if listener.Name == name {
+			_, port, err := net.SplitHostPort(listener.Address)
+			return port, err
+		}
+	}
+	return "", fmt.Errorf("unknown listener %q", name)
+}
+
+// serve blocks until listener is shut down or fails. Certificates are watched only while it serves.
+func (l *Listener) serve() error {
+	ctx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
+	if l.ProxyProtocol == nil {
+		if l.TLSConfig != nil {
+			go l.certificates.Watch(ctx, certificatesWatchInterval)
+			// certificates are provided by TLSConfig.GetCertificate
+			return l.server.ListenAndServeTLS("", "")
+		}
+		return l.server.ListenAndServe()
+	}
+
+	// PROXY protocol header precedes TLS handshake, so it is decoded from the raw connection
+	netListener, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		return err
+	}
+	l.ProxyProtocol.Listener = netListener
+	if l.TLSConfig != nil {
+		go l.certificates.Watch(ctx, certificatesWatchInterval)
+		return l.server.ServeTLS(l.ProxyProtocol, "", "")
+	}
+	return l.server.Serve(l.ProxyProtocol)
+}
@@ -1,73 +1,346 @@
 package load_balancer
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/ajablonsk1/gload-balancer/internal/clientip"
 	c "github.com/ajablonsk1/gload-balancer/internal/config"
 	"github.com/ajablonsk1/gload-balancer/internal/handler"
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+const (
+	healthCheckInterval  = 3 * time.Second
+	sessionSweepInterval = 30 * time.Second
+	scoreLogInterval     = time.Minute
 )
 
 type LoadBalancer struct {
-	Addr         string
+	// ProxyHandler distributes requests between servers of the default pool. It is nil when
+	// config has no top-level servers.
 	ProxyHandler *handler.ProxyHandler
+	// Pools are handlers of named pools, which routes point at.
+	Pools map[string]*handler.ProxyHandler
+	// Router serves listeners without servers of their own.
+	Router    *handler.Router
+	Listeners []*Listener
+
+	configPath   string
+	configFormat string
+	config       *c.Config
+	reloadMu     sync.Mutex
+
+	// background is done after Shutdown, it stops health checks, config watching and other
+	// goroutines started by Start. It is created with load balancer, so Shutdown racing with
+	// Start still stops them.
+	background     context.Context
+	stopBackground context.CancelFunc
 }
 
 func NewLoadBalancer(path string) (*LoadBalancer, error) {
-	config, err := c.GetConfig(path)
+	return NewLoadBalancerFormat(path, "")
+}
+
+// NewLoadBalancerFormat creates load balancer from config file in given format (json, yaml or toml).
+// Empty format means it is detected from file extension.
+func NewLoadBalancerFormat(path string, format string) (*LoadBalancer, error) {
+	config, err := c.GetConfigFormat(path, format)
 	if err != nil {
 		return nil, err
 	}
 
-	addr, err := config.GetAddress()
-	if err != nil {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
-	url, err := url.Parse(addr)
+	loadBalancer := &LoadBalancer{
+		configPath:   path,
+		configFormat: format,
+		config:       config,
+	}
+	loadBalancer.background, loadBalancer.stopBackground = context.WithCancel(context.Background())
+
+	options, err := newUpstreamOptions(config)
 	if err != nil {
 		return nil, err
 	}
 
-	strategy, err := config.GetLoadStrategy()
+	if len(config.Servers) > 0 {
+		loadBalancer.ProxyHandler, err = newProxyHandler(c.DefaultPoolName, &config.PoolConfig, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loadBalancer.Pools = make(map[string]*handler.ProxyHandler, len(config.Pools))
+	for name, pool := range config.Pools {
+		loadBalancer.Pools[name], err = newProxyHandler(name, &pool, options)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+
+	routes, err := buildRoutes(config.Routes, loadBalancer.Pools, loadBalancer.ProxyHandler)
 	if err != nil {
 		return nil, err
 	}
+	loadBalancer.Router = handler.NewRouter(routes, loadBalancer.ProxyHandler)
+
+	listeners := config.GetListeners()
+	for _, listenerConfig := range listeners {
+		listener, err := newListener(listenerConfig, listeners, loadBalancer.Router, options)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", listenerConfig.Name, err)
+		}
+		loadBalancer.Listeners = append(loadBalancer.Listeners, listener)
+	}
+
+	return loadBalancer, nil
+}
 
-	serverPool, err := config.GetServerPool()
+// upstreamOptions are settings shared by upstreams of all pools.
+type upstreamOptions struct {
+	noUpstream *handler.NoUpstreamResponse
+	clientIP   *clientip.Resolver
+}
+
+func newUpstreamOptions(config *c.Config) (upstreamOptions, error) {
+	noUpstream, err := config.NoHealthyUpstream.GetResponse()
+	if err != nil {
+		return upstreamOptions{}, fmt.Errorf("no_healthy_upstream: %w", err)
+	}
+	clientIP, err := config.ClientIP.GetResolver()
+	if err != nil {
+		return upstreamOptions{}, fmt.Errorf("client_ip: %w", err)
+	}
+	return upstreamOptions{noUpstream: noUpstream, clientIP: clientIP}, nil
+}
+
+func newProxyHandler(name string, pool *c.PoolConfig, options upstreamOptions) (*handler.ProxyHandler, error) {
+	// servers of a new pool are all new, so they already have weights from config
+	upstream, _, err := buildUpstream(pool, nil, nil, options)
 	if err != nil {
 		return nil, err
 	}
+	return handler.NewProxyHandler(name, upstream), nil
+}
+
+// buildUpstream builds upstream of pool, reusing unchanged servers and sessions of current upstream.
+// It also returns new weights of reused servers, which are not applied yet.
+func buildUpstream(pool *c.PoolConfig, current *handler.Upstream, previous *c.PoolConfig, options upstreamOptions) (*handler.Upstream, map[*model.Server]int, error) {
+	strategy, err := pool.GetLoadStrategy()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var currentPool *model.ServerPool
+	var currentAffinity handler.Affinity
+	if current != nil {
+		currentPool, currentAffinity = current.ServerPool, current.Affinity
+	}
+	serverPool, err := pool.UpdateServerPool(currentPool, previous)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inheritor, ok := strategy.(model.Inheritor); ok && current != nil {
+		inheritor.Inherit(current.Strategy, serverPool)
+	}
 
-	proxyHandler := &handler.ProxyHandler{
+	return &handler.Upstream{
 		Strategy:   strategy,
 		ServerPool: serverPool,
+		NoUpstream: options.noUpstream,
+		ClientIP:   options.clientIP,
+		Affinity:   pool.GetAffinity(serverPool, currentAffinity),
+	}, pool.ChangedWeights(serverPool), nil
+}
+
+func buildRoutes(configs []c.RouteConfig, pools map[string]*handler.ProxyHandler, defaultHandler *handler.ProxyHandler) ([]*handler.Route, error) {
+	routes := make([]*handler.Route, len(configs))
+	for i, config := range configs {
+		route := &handler.Route{
+			Name:        config.Name,
+			Host:        config.Host,
+			PathPrefix:  config.PathPrefix,
+			Methods:     config.Methods,
+			Headers:     config.Headers,
+			StripPrefix: config.StripPrefix,
+			Rewrite:     config.Rewrite,
+			Upstream:    pools[config.Pool],
+		}
+		if config.Pool == c.DefaultPoolName {
+			route.Upstream = defaultHandler
+		}
+		if route.Upstream == nil {
+			return nil, fmt.Errorf("route %s: unknown pool %q", config.Name, config.Pool)
+		}
+		if config.PathRegex != "" {
+			regex, err := regexp.Compile(config.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", config.Name, err)
+			}
+			route.PathRegex = regex
+		}
+		routes[i] = route
 	}
+	return routes, nil
+}
 
-	return &LoadBalancer{
-		Addr:         url.String(),
-		ProxyHandler: proxyHandler,
-	}, nil
+// proxyHandlers returns handlers of all pools.
+func (l *LoadBalancer) proxyHandlers() []*handler.ProxyHandler {
+	handlers := make([]*handler.ProxyHandler, 0, len(l.Pools)+len(l.Listeners)+1)
+	if l.ProxyHandler != nil {
+		handlers = append(handlers, l.ProxyHandler)
+	}
+	for _, name := range sortedNames(l.Pools) {
+		handlers = append(handlers, l.Pools[name])
+	}
+	for _, listener := range l.Listeners {
+		if listener.ProxyHandler != nil {
+			handlers = append(handlers, listener.ProxyHandler)
+		}
+	}
+	return handlers
 }
 
-func (l *LoadBalancer) RunHealthChecks() {
-	ticker := time.NewTicker(3 * time.Second)
+func sortedNames(pools map[string]*handler.ProxyHandler) []string {
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *LoadBalancer) upstreams() []*handler.Upstream {
+	// pools are replaced on reload
+	l.reloadMu.Lock()
+	handlers := l.proxyHandlers()
+	l.reloadMu.Unlock()
+
+	upstreams := make([]*handler.Upstream, len(handlers))
+	for i, proxyHandler := range handlers {
+		upstreams[i] = proxyHandler.Upstream()
+	}
+	return upstreams
+}
+
+// every calls fn every interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		l.ProxyHandler.ServerPool.HealthCheck()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn()
+		}
 	}
 }
 
-func (l *LoadBalancer) Start() {
-	server := &http.Server{
-		Addr:    l.Addr,
-		Handler: l.ProxyHandler,
+// RunHealthChecks periodically checks health of servers of all pools until ctx is done.
+func (l *LoadBalancer) RunHealthChecks(ctx context.Context) {
+	every(ctx, healthCheckInterval, func() {
+		for _, upstream := range l.upstreams() {
+			upstream.ServerPool.HealthCheck()
+		}
+	})
+}
+
+// RunSessionSweeper periodically removes expired sticky sessions and sessions of dead servers
+// from all pools, so that memory held by clients which went away is released. It stops when
+// ctx is done.
+func (l *LoadBalancer) RunSessionSweeper(ctx context.Context) {
+	every(ctx, sessionSweepInterval, func() {
+		for _, upstream := range l.upstreams() {
+			upstream.SweepSessions()
+		}
+	})
+}
+
+// RunScoreLogger periodically logs scores of servers of pools which strategy ranks servers by score,
+// e.g. least-response-time, so operators can see why servers get the traffic they get. It stops
+// when ctx is done.
+func (l *LoadBalancer) RunScoreLogger(ctx context.Context) {
+	every(ctx, scoreLogInterval, l.logScores)
+}
+
+func (l *LoadBalancer) logScores() {
+	l.reloadMu.Lock()
+	handlers := l.proxyHandlers()
+	l.reloadMu.Unlock()
+
+	for _, proxyHandler := range handlers {
+		scores := proxyHandler.Scores()
+		if len(scores) == 0 {
+			continue
+		}
+		formatted := make([]string, len(scores))
+		for i, score := range scores {
+			formatted[i] = score.String()
+		}
+		log.Printf("pool %s: scores of servers: %s", proxyHandler.Name, strings.Join(formatted, ", "))
+	}
+}
+
+// Start serves all listeners and blocks until they stop. When any listener fails, the others
+// are shut down as well and the error is returned. It returns nil after Shutdown.
+func (l *LoadBalancer) Start() error {
+	go l.RunHealthChecks(l.background)
+	go l.RunSessionSweeper(l.background)
+	go l.RunScoreLogger(l.background)
+	go l.WatchConfig(l.background)
+	go l.ReloadOnSignal(l.background)
+
+	errs := make(chan error, len(l.Listeners))
+	for _, listener := range l.Listeners {
+		go func(listener *Listener) {
+			errs <- listener.serve()
+		}(listener)
 	}
 
-	go l.RunHealthChecks()
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		l.Shutdown(context.Background())
+	}
+	for i := 1; i < len(l.Listeners); i++ {
+		<-errs
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully stops all listeners, waiting for in-flight requests until ctx is done,
+// and goroutines started by Start.
+func (l *LoadBalancer) Shutdown(ctx context.Context) error {
+	l.stopBackground()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(l.Listeners))
+	for i, listener := range l.Listeners {
+		wg.Add(1)
+		go func(i int, listener *Listener) {
+			defer wg.Done()
+			if err := listener.server.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("listener %s: %w", listener.Name, err)
+			}
+		}(i, listener)
+	}
+	wg.Wait()
 
-	log.Fatal(server.ListenAndServe())
+	return errors.Join(errs...)
 }
@@ -1,11 +1,18 @@
 package load_balancer
 
 import (
+	"bufio"
+	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
 )
 
 func TestNewLoadBalancer(t *testing.T) {
@@ -15,7 +22,7 @@ func TestNewLoadBalancer(t *testing.T) {
 		if err != nil {
 			t.Errorf("error from new load balancer: %s", err)
 		}
-		if lb.Addr != "localhost:8080" {
+		if len(lb.Listeners) != 1 || lb.Listeners[0].Addr != "localhost:8080" {
 			t.Errorf("wrong load balancer address")
 		}
 	})
@@ -56,3 +63,445 @@ func TestLoadBalancer(t *testing.T) {
 		}
 	})
 }
+
+func TestListeners(t *testing.T) {
+	t.Run("creates listeners with own and default pools", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, `
+listeners:
+  - name: web
+    address: 127.0.0.1:0
+  - name: api
+    address: 127.0.0.2:0
+    strategy: ip-hash
+    servers:
+      - host: localhost:2222
+strategy: round-robin
+servers:
+  - host: localhost:1111
+`)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+
+		if len(lb.Listeners) != 2 {
+			t.Fatalf("wrong number of listeners. got %d want 2", len(lb.Listeners))
+		}
+		if lb.Listeners[0].Handler != lb.Router || lb.Listeners[0].ProxyHandler != nil {
+			t.Errorf("listener without servers should use router")
+		}
+		api := lb.Listeners[1].ProxyHandler.Upstream()
+		if _, ok := api.Strategy.(*model.IPHash); !ok || api.ServerPool.Servers[0].Url.Host != "localhost:2222" {
+			t.Errorf("listener with servers should use its own pool")
+		}
+		if len(lb.upstreams()) != 2 {
+			t.Errorf("wrong number of upstreams. got %d want 2", len(lb.upstreams()))
+		}
+	})
+
+	t.Run("shuts down all listeners together", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		writeConfig(t, path, `{
+			"listeners": [{"address": "127.0.0.1:0"}, {"address": "127.0.0.2:0"}],
+			"servers": [{"host": "localhost:1111"}]
+		}`)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+
+		stopped := make(chan error)
+		go func() { stopped <- lb.Start() }()
+		time.Sleep(100 * time.Millisecond)
+
+		if err := lb.Shutdown(context.Background()); err != nil {
+			t.Errorf("error from shutdown: %s", err)
+		}
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Errorf("error from start: %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("load balancer did not stop")
+		}
+	})
+
+	t.Run("stops background goroutines on shutdown", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		writeConfig(t, path, `{
+			"listeners": [{"address": "127.0.0.1:0"}],
+			"servers": [{"host": "localhost:1111"}]
+		}`)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+
+		runs := []func(context.Context){lb.RunHealthChecks, lb.RunSessionSweeper, lb.RunScoreLogger, lb.WatchConfig, lb.ReloadOnSignal}
+		stopped := make(chan struct{}, len(runs))
+		for _, run := range runs {
+			go func(run func(context.Context)) {
+				run(lb.background)
+				stopped <- struct{}{}
+			}(run)
+		}
+
+		if err := lb.Shutdown(context.Background()); err != nil {
+			t.Errorf("error from shutdown: %s", err)
+		}
+		for range runs {
+			select {
+			case <-stopped:
+			case <-time.After(time.Second):
+				t.Fatalf("background goroutine did not stop")
+			}
+		}
+	})
+
+	t.Run("stops all listeners when one fails", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		writeConfig(t, path, `{
+			"listeners": [{"address": "127.0.0.1:0"}, {"address": "256.0.0.1:1"}],
+			"servers": [{"host": "localhost:1111"}]
+		}`)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+
+		stopped := make(chan error)
+		go func() { stopped <- lb.Start() }()
+
+		select {
+		case err := <-stopped:
+			if err == nil {
+				t.Errorf("expected error from start")
+			}
+		case <-time.After(time.Second):
+			t.Errorf("load balancer did not stop")
+		}
+	})
+}
+
+func TestProxyProtocol(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Forwarded-For"))
+	}))
+	defer backend.Close()
+
+	// free port for the listener
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := free.Addr().String()
+	free.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+listeners:
+  - address: `+address+`
+    proxy_protocol:
+      trusted_sources: [127.0.0.1]
+servers:
+  - host: `+backend.URL+`
+`)
+	lb, err := NewLoadBalancer(path)
+	if err != nil {
+		t.Fatalf("error from new load balancer: %s", err)
+	}
+	go lb.Start()
+	defer lb.Shutdown(context.Background())
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", address); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("error while connecting to listener: %s", err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 56324 443\r\nGET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("error while reading response: %s", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	if string(body) != "203.0.113.7" {
+		t.Errorf("wrong client address seen by backend. got %s want %s", body, "203.0.113.7")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path)
+		}))
+	}
+	api, static, fallback := backend("api"), backend("static"), backend("default")
+	defer api.Close()
+	defer static.Close()
+	defer fallback.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+address: localhost:8080
+pools:
+  api:
+    strategy: least-connection
+    servers:
+      - host: `+api.URL+`
+routes:
+  - path_prefix: /api/
+    pool: api
+    strip_prefix: true
+servers:
+  - host: `+fallback.URL+`
+`)
+	lb, err := NewLoadBalancer(path)
+	if err != nil {
+		t.Fatalf("error from new load balancer: %s", err)
+	}
+
+	get := func(path string) string {
+		response := httptest.NewRecorder()
+		lb.Listeners[0].Handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response.Body.String()
+	}
+
+	if got := get("/api/users"); got != "api /users" {
+		t.Errorf("wrong response. got %s want %s", got, "api /users")
+	}
+	if got := get("/index.html"); got != "default /index.html" {
+		t.Errorf("wrong response. got %s want %s", got, "default /index.html")
+	}
+
+	writeConfig(t, path, `
+address: localhost:8080
+pools:
+  api:
+    strategy: least-connection
+    servers:
+      - host: `+api.URL+`
+  static:
+    servers:
+      - host: `+static.URL+`
+routes:
+  - path_prefix: /api/
+    pool: api
+    rewrite: /v2/
+  - path_regex: \.(css|js)$
+    pool: static
+servers:
+  - host: `+fallback.URL+`
+`)
+	apiHandler := lb.Pools["api"]
+	if err := lb.Reload(); err != nil {
+		t.Fatalf("error from reload: %s", err)
+	}
+
+	if lb.Pools["api"] != apiHandler {
+		t.Errorf("handler of unchanged pool was replaced")
+	}
+	if got := get("/api/users"); got != "api /v2/users" {
+		t.Errorf("wrong response. got %s want %s", got, "api /v2/users")
+	}
+	if got := get("/app.js"); got != "static /app.js" {
+		t.Errorf("wrong response. got %s want %s", got, "static /app.js")
+	}
+}
+
+func writeConfig(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("error while writing config: %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Run("swaps server pool and strategy", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		writeConfig(t, path, `{
+			"address": "localhost:8080",
+			"strategy": "round-robin",
+			"servers": [{"host": "localhost:1111"}, {"host": "localhost:1112"}, {"host": "https://localhost:1114"}]
+		}`)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+		old := lb.ProxyHandler.Upstream()
+		old.ServerPool.AddStickySession("10.0.0.1", old.ServerPool.Servers[0])
+		old.ServerPool.AddStickySession("10.0.0.2", old.ServerPool.Servers[1])
+		old.ServerPool.AddStickySession("10.0.0.4", old.ServerPool.Servers[2])
+		old.ServerPool.Servers[1].InFlight.Store(3)
+		old.ServerPool.Servers[2].SetAlive(false)
+
+		writeConfig(t, path, `{
+			"address": "localhost:8080",
+			"strategy": "ip-hash",
+			"servers": [
+				{"host": "localhost:1111"},
+				{"host": "localhost:1112", "weight": 5},
+				{"host": "localhost:1113"},
+				{"host": "https://localhost:1114", "tls": {"server_name": "backend.local"}}
+			]
+		}`)
+		if err := lb.Reload(); err != nil {
+			t.Fatalf("error from reload: %s", err)
+		}
+
+		upstream := lb.ProxyHandler.Upstream()
+		if _, ok := upstream.Strategy.(*model.IPHash); !ok {
+			t.Errorf("wrong strategy. got %T want %T", upstream.Strategy, &model.IPHash{})
+		}
+		if len(upstream.ServerPool.Servers) != 4 {
+			t.Fatalf("wrong number of servers. got %d want 4", len(upstream.ServerPool.Servers))
+		}
+		if upstream.ServerPool.Servers[0] != old.ServerPool.Servers[0] {
+			t.Errorf("unchanged server was not reused")
+		}
+		reweighted := upstream.ServerPool.Servers[1]
+		if reweighted != old.ServerPool.Servers[1] || reweighted.GetWeight() != 5 || reweighted.InFlight.Load() != 3 {
+			t.Errorf("server with changed weight should be reused with new weight")
+		}
+		if got := upstream.ServerPool.GetServerFromStickySession("10.0.0.2"); got != reweighted {
+			t.Errorf("sticky session of server with changed weight was not kept")
+		}
+		changed := upstream.ServerPool.Servers[3]
+		if changed == old.ServerPool.Servers[2] || changed.IsAlive() {
+			t.Errorf("changed server should be recreated with old health status")
+		}
+		if len(old.ServerPool.Servers) != 3 {
+			t.Errorf("old server pool was modified")
+		}
+		if got := upstream.ServerPool.GetServerFromStickySession("10.0.0.1"); got != upstream.ServerPool.Servers[0] {
+			t.Errorf("sticky session of unchanged server was not kept")
+		}
+		if got := upstream.ServerPool.NumberOfStickySessions(changed); got != 0 {
+			t.Errorf("sticky sessions of recreated server should be dropped, got %d", got)
+		}
+	})
+
+	t.Run("closes idle connections of removed servers", func(t *testing.T) {
+		closed := make(chan struct{}, 1)
+		removed := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		removed.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateClosed {
+				closed <- struct{}{}
+			}
+		}
+		removed.Start()
+		defer removed.Close()
+
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, "address: localhost:8080\nservers:\n  - host: "+removed.URL+"\n")
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+		// leaves idle keep-alive connection to the server
+		lb.ProxyHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		writeConfig(t, path, "address: localhost:8080\nservers:\n  - host: localhost:1111\n")
+		if err := lb.Reload(); err != nil {
+			t.Fatalf("error from reload: %s", err)
+		}
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Errorf("idle connection to removed server was not closed")
+		}
+	})
+
+	t.Run("keeps latencies of unchanged servers", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		content := `
+address: localhost:8080
+strategy:
+  name: least-response-time
+  params:
+    decay: 1m
+servers:
+  - host: localhost:1111
+`
+		writeConfig(t, path, content)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+		old := lb.ProxyHandler.Upstream()
+		old.Strategy.(model.LatencyObserver).ObserveLatency(old.ServerPool.Servers[0], 100*time.Millisecond)
+
+		writeConfig(t, path, content+"  - host: localhost:1112\n")
+		if err := lb.Reload(); err != nil {
+			t.Fatalf("error from reload: %s", err)
+		}
+
+		if lb.ProxyHandler.Upstream().Strategy == old.Strategy {
+			t.Fatalf("strategy was not rebuilt")
+		}
+		scores := lb.ProxyHandler.Scores()
+		if len(scores) != 2 || scores[0].Latency < 90*time.Millisecond || scores[1].Latency != 0 {
+			t.Errorf("wrong scores after reload. got %v", scores)
+		}
+	})
+
+	t.Run("keeps weights when reload fails", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, "address: localhost:8080\nservers:\n  - host: localhost:1111\n")
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+		old := lb.ProxyHandler.Upstream()
+
+		writeConfig(t, path, `
+address: localhost:8080
+pools:
+  b:
+    servers:
+      - host: https://localhost:2222
+        tls:
+          ca_file: /nonexistent/ca.pem
+servers:
+  - host: localhost:1111
+    weight: 7
+`)
+		if err := lb.Reload(); err == nil {
+			t.Fatalf("expected error from reload")
+		}
+
+		if got := old.ServerPool.Servers[0].GetWeight(); got != 1 {
+			t.Errorf("weight of server changed by rejected reload. got %d want 1", got)
+		}
+	})
+
+	t.Run("keeps old config when new one is invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		writeConfig(t, path, `{"address": "localhost:8080", "servers": [{"host": "localhost:1111"}]}`)
+		lb, err := NewLoadBalancer(path)
+		if err != nil {
+			t.Fatalf("error from new load balancer: %s", err)
+		}
+		old := lb.ProxyHandler.Upstream()
+
+		writeConfig(t, path, `{"address": "localhost:8080", "strategy": "nope", "servers": []}`)
+		if err := lb.Reload(); err == nil {
+			t.Errorf("expected error from reload")
+		}
+
+		if lb.ProxyHandler.Upstream() != old {
+			t.Errorf("upstream changed after rejected reload")
+		}
+	})
+}
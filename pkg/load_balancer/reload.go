@@ -0,0 +1,212 @@
+package load_balancer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"time"
+
+	c "github.com/ajablonsk1/gload-balancer/internal/config"
+	"github.com/ajablonsk1/gload-balancer/internal/handler"
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+	"github.com/ajablonsk1/gload-balancer/internal/watcher"
+)
+
+const configWatchInterval = 2 * time.Second
+
+// Reload reads config file again and atomically swaps server pool and strategy of every pool of
+// the running load balancer, adds and removes named pools and replaces routes. Servers present in
+// both configs keep their state, idle connections to removed and replaced servers are closed.
+// Invalid config is rejected and the old one stays in use. Changes of listeners require restart.
+func (l *LoadBalancer) Reload() error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	config, err := c.GetConfigFormat(l.configPath, l.configFormat)
+	if err != nil {
+		return err
+	}
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	options, err := newUpstreamOptions(config)
+	if err != nil {
+		return err
+	}
+
+	// everything is built before anything is swapped, so a failure leaves all pools untouched
+	updates := make([]upstreamUpdate, 0, len(l.Listeners)+len(config.Pools)+1)
+	var defaultHandler *handler.ProxyHandler
+	if len(config.Servers) > 0 {
+		defaultHandler, updates, err = updateOrCreate(l.ProxyHandler, c.DefaultPoolName, &config.PoolConfig, &l.config.PoolConfig, options, updates)
+		if err != nil {
+			return err
+		}
+	}
+
+	pools := make(map[string]*handler.ProxyHandler, len(config.Pools))
+	for name, pool := range config.Pools {
+		previous := l.config.Pools[name]
+		pools[name], updates, err = updateOrCreate(l.Pools[name], name, &pool, &previous, options, updates)
+		if err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+
+	routes, err := buildRoutes(config.Routes, pools, defaultHandler)
+	if err != nil {
+		return err
+	}
+
+	previousListeners := listenersByName(l.config)
+	nextListeners := listenersByName(config)
+	for _, listener := range l.Listeners {
+		if listener.ProxyHandler == nil {
+			continue
+		}
+		next, ok := nextListeners[listener.Name]
+		if !ok || len(next.Servers) == 0 {
+			continue
+		}
+		previous := previousListeners[listener.Name]
+		update, err := prepareUpdate(listener.ProxyHandler, &next.PoolConfig, &previous.PoolConfig, options)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", listener.Name, err)
+		}
+		updates = append(updates, update)
+	}
+
+	if !reflect.DeepEqual(listenersWithoutPools(l.config), listenersWithoutPools(config)) {
+		log.Printf("config reload: change of listeners requires restart, only server pools are reloaded")
+	}
+
+	previousServers := servers(l.proxyHandlers())
+	for _, update := range updates {
+		// weights go first, so that tables of strategies built for the new pool use them
+		for server, weight := range update.weights {
+			server.Weight.Store(int64(weight))
+		}
+		update.handler.SetUpstream(update.upstream)
+	}
+	l.Router.SetRoutes(routes, defaultHandler)
+	l.ProxyHandler = defaultHandler
+	l.Pools = pools
+	l.config = config
+
+	nextServers := servers(l.proxyHandlers())
+	for server := range previousServers {
+		if !nextServers[server] {
+			server.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// servers returns servers of current upstreams of handlers.
+func servers(handlers []*handler.ProxyHandler) map[*model.Server]bool {
+	servers := make(map[*model.Server]bool)
+	for _, proxyHandler := range handlers {
+		for _, server := range proxyHandler.Upstream().ServerPool.Servers {
+			servers[server] = true
+		}
+	}
+	return servers
+}
+
+// updateOrCreate prepares update of existing pool handler or creates handler for a new pool.
+func updateOrCreate(proxyHandler *handler.ProxyHandler, name string, next *c.PoolConfig, previous *c.PoolConfig, options upstreamOptions, updates []upstreamUpdate) (*handler.ProxyHandler, []upstreamUpdate, error) {
+	if proxyHandler == nil {
+		proxyHandler, err := newProxyHandler(name, next, options)
+		return proxyHandler, updates, err
+	}
+
+	update, err := prepareUpdate(proxyHandler, next, previous, options)
+	if err != nil {
+		return nil, updates, err
+	}
+	return proxyHandler, append(updates, update), nil
+}
+
+type upstreamUpdate struct {
+	handler  *handler.ProxyHandler
+	upstream *handler.Upstream
+	// weights are new weights of servers reused from current upstream
+	weights map[*model.Server]int
+}
+
+func prepareUpdate(proxyHandler *handler.ProxyHandler, next *c.PoolConfig, previous *c.PoolConfig, options upstreamOptions) (upstreamUpdate, error) {
+	upstream, weights, err := buildUpstream(next, proxyHandler.Upstream(), previous, options)
+	if err != nil {
+		return upstreamUpdate{}, err
+	}
+
+	return upstreamUpdate{
+		handler:  proxyHandler,
+		upstream: upstream,
+		weights:  weights,
+	}, nil
+}
+
+func listenersByName(config *c.Config) map[string]c.ListenerConfig {
+	listeners := make(map[string]c.ListenerConfig)
+	for _, listener := range config.GetListeners() {
+		listeners[listener.Name] = listener
+	}
+	return listeners
+}
+
+// listenerShape is the part of listener config which cannot be reloaded.
+type listenerShape struct {
+	config  c.ListenerConfig
+	ownPool bool
+}
+
+func listenersWithoutPools(config *c.Config) []listenerShape {
+	listeners := config.GetListeners()
+	shapes := make([]listenerShape, len(listeners))
+	for i, listener := range listeners {
+		shapes[i].ownPool = len(listener.Servers) > 0
+		listener.PoolConfig = c.PoolConfig{}
+		shapes[i].config = listener
+	}
+	return shapes
+}
+
+func (l *LoadBalancer) reloadAndLog(reason string) {
+	if err := l.Reload(); err != nil {
+		log.Printf("config reload (%s) rejected, keeping old config: %s", reason, err)
+		return
+	}
+	log.Printf("config reloaded (%s)", reason)
+}
+
+// WatchConfig reloads config every time config file changes on disk, until ctx is done.
+func (l *LoadBalancer) WatchConfig(ctx context.Context) {
+	watcher.NewFileWatcher(l.configPath).Watch(ctx, configWatchInterval, func() {
+		l.reloadAndLog("file changed")
+	})
+}
+
+// ReloadOnSignal reloads config every time the process receives SIGHUP, until ctx is done.
+func (l *LoadBalancer) ReloadOnSignal(ctx context.Context) {
+	if len(reloadSignals) == 0 {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, reloadSignals...)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			l.reloadAndLog(sig.String())
+		}
+	}
+}
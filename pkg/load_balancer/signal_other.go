@@ -0,0 +1,7 @@
+//go:build !unix
+
+package load_balancer
+
+import "os"
+
+var reloadSignals []os.Signal
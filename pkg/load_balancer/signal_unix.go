@@ -0,0 +1,10 @@
+//go:build unix
+
+package load_balancer
+
+import (
+	"os"
+	"syscall"
+)
+
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,121 @@
+package strategy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+type consistentHashParams struct {
+	// Key is client-ip (default), path, header:<name> or cookie:<name>.
+	Key string `json:"key"`
+	// Replicas is the number of virtual nodes of server with weight 1.
+	Replicas int `json:"replicas"`
+	// LoadFactor bounds requests in flight of server to LoadFactor times its share, nil means
+	// model.DefaultLoadFactor and zero disables the bound.
+	LoadFactor *float64 `json:"load_factor"`
+}
+
+func newConsistentHash(params consistentHashParams) (Strategy, error) {
+	key, err := model.ParseHashKey(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	if params.Replicas < 0 {
+		return nil, fmt.Errorf("replicas must not be negative")
+	}
+	loadFactor := model.DefaultLoadFactor
+	if params.LoadFactor != nil {
+		loadFactor = *params.LoadFactor
+	}
+	if loadFactor != 0 && loadFactor < 1 {
+		return nil, fmt.Errorf("load_factor must be at least 1, or 0 to disable bounded loads")
+	}
+	return model.NewConsistentHash(key, params.Replicas, loadFactor), nil
+}
+
+type maglevParams struct {
+	// Key is client-ip (default), path, header:<name> or cookie:<name>.
+	Key string `json:"key"`
+	// TableSize is prime size of lookup table, zero means model.DefaultMaglevTableSize.
+	TableSize int `json:"table_size"`
+}
+
+func newMaglev(params maglevParams) (Strategy, error) {
+	key, err := model.ParseHashKey(params.Key)
+	if err != nil {
+		return nil, err
+	}
+	if params.TableSize != 0 && !isPrime(params.TableSize) {
+		return nil, fmt.Errorf("table_size must be prime, e.g. %d", model.DefaultMaglevTableSize)
+	}
+	return model.NewMaglev(key, params.TableSize), nil
+}
+
+type leastConnectionParams struct {
+	// By is requests (default), counting requests in flight, or connections, counting open
+	// connections to servers.
+	By string `json:"by"`
+}
+
+func newLeastConnection(params leastConnectionParams) (Strategy, error) {
+	by, err := model.ParseLoadMetric(params.By)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LeastSession{By: by}, nil
+}
+
+func newWeightedLeastConnection(params leastConnectionParams) (Strategy, error) {
+	by, err := model.ParseLoadMetric(params.By)
+	if err != nil {
+		return nil, err
+	}
+	return &model.WeightedLeastSession{By: by}, nil
+}
+
+type leastResponseTimeParams struct {
+	// Decay is the time after which old latency weighs 1/e of what it did, e.g. "10s".
+	Decay string `json:"decay"`
+}
+
+func newLeastResponseTime(params leastResponseTimeParams) (Strategy, error) {
+	decay := model.DefaultEWMADecay
+	if params.Decay != "" {
+		var err error
+		if decay, err = time.ParseDuration(params.Decay); err != nil {
+			return nil, fmt.Errorf("decay: invalid duration %q", params.Decay)
+		}
+		if decay <= 0 {
+			return nil, fmt.Errorf("decay must be positive")
+		}
+	}
+	return model.NewPeakEWMA(decay), nil
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for d := 2; d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	Register("round-robin", func(NoParams) (Strategy, error) { return &model.RoundRobin{}, nil })
+	Register("random", func(NoParams) (Strategy, error) { return &model.Random{}, nil })
+	Register("weighted-random", func(NoParams) (Strategy, error) { return &model.WeightedRandom{}, nil })
+	Register("weighted-round-robin", func(NoParams) (Strategy, error) { return &model.WeightedRoundRobin{}, nil })
+	Register("ip-hash", func(NoParams) (Strategy, error) { return &model.IPHash{}, nil })
+	Register("least-connection", newLeastConnection)
+	Register("weighted-least-connection", newWeightedLeastConnection)
+	Register("consistent-hash", newConsistentHash)
+	Register("maglev", newMaglev)
+	Register("least-response-time", newLeastResponseTime)
+	Register("p2c", func(NoParams) (Strategy, error) { return &model.PowerOfTwoChoices{}, nil })
+}
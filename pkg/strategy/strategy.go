@@ -0,0 +1,109 @@
+package strategy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+)
+
+// Types used by strategies, so that packages outside of this module can implement them.
+type (
+	Strategy                 = model.Strategy
+	LoadDistributionStrategy = model.LoadDistributionStrategy
+	Request                  = model.Request
+	Server                   = model.Server
+	ServerPool               = model.ServerPool
+	// LatencyObserver, Scorer and Inheritor are optional interfaces of strategies.
+	LatencyObserver = model.LatencyObserver
+	Scorer          = model.Scorer
+	Inheritor       = model.Inheritor
+	ServerScore     = model.ServerScore
+)
+
+// NoParams is params type of strategies without settings.
+type NoParams struct{}
+
+type factory func(params map[string]interface{}) (Strategy, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]factory)
+)
+
+// Register makes strategy available in config under name. Params of the strategy in config are
+// decoded into P and unknown params are rejected. Missing params are left as zero values of P,
+// newStrategy applies its defaults and reports invalid values.
+// Register panics when name is empty or already registered, it is meant to be called from init.
+func Register[P any](name string, newStrategy func(params P) (Strategy, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	if name == "" {
+		panic("strategy: empty name")
+	}
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("strategy: %q registered twice", name))
+	}
+	factories[name] = func(raw map[string]interface{}) (Strategy, error) {
+		var params P
+		if len(raw) > 0 {
+			data, err := json.Marshal(raw)
+			if err != nil {
+				return nil, err
+			}
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&params); err != nil {
+				return nil, fmt.Errorf("params: %w", err)
+			}
+		}
+		return newStrategy(params)
+	}
+}
+
+// Adapt turns strategy which knows only client address into Strategy.
+func Adapt(strategy LoadDistributionStrategy) Strategy {
+	return model.Adapt(strategy)
+}
+
+// Registered tells if there is strategy registered under name.
+func Registered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Names returns sorted names of registered strategies.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates strategy registered under name with given params.
+func New(name string, params map[string]interface{}) (Strategy, error) {
+	mu.RLock()
+	newStrategy, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, UnknownError(name)
+	}
+	return newStrategy(params)
+}
+
+// UnknownError is returned for names which are not registered.
+type UnknownError string
+
+func (e UnknownError) Error() string {
+	return fmt.Sprintf("unknown strategy %q, must be one of %s", string(e), strings.Join(Names(), ", "))
+}
@@ -0,0 +1,116 @@
+package strategy_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ajablonsk1/gload-balancer/internal/model"
+	"github.com/ajablonsk1/gload-balancer/pkg/strategy"
+)
+
+// nth picks server with configured index.
+type nth struct {
+	index int
+}
+
+func (n *nth) Pick(serverPool *strategy.ServerPool, request *strategy.Request) *strategy.Server {
+	return serverPool.Servers[n.index]
+}
+
+type nthParams struct {
+	Index int `json:"index"`
+}
+
+func init() {
+	strategy.Register("test-nth", func(params nthParams) (strategy.Strategy, error) {
+		if params.Index < 0 {
+			return nil, fmt.Errorf("index must not be negative")
+		}
+		return &nth{index: params.Index}, nil
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("lists builtin and registered strategies", func(t *testing.T) {
+		names := strategy.Names()
+		for _, name := range []string{"round-robin", "ip-hash", "test-nth"} {
+			if !slices.Contains(names, name) || !strategy.Registered(name) {
+				t.Errorf("strategy %s is not registered, got %v", name, names)
+			}
+		}
+		if !slices.IsSorted(names) {
+			t.Errorf("names are not sorted. got %v", names)
+		}
+	})
+
+	t.Run("decodes typed params", func(t *testing.T) {
+		s, err := strategy.New("test-nth", map[string]interface{}{"index": 2.0})
+		if err != nil {
+			t.Fatalf("error creating strategy: %s", err)
+		}
+		if got := s.(*nth).index; got != 2 {
+			t.Errorf("wrong index. got %d want 2", got)
+		}
+
+		s, err = strategy.New("test-nth", nil)
+		if err != nil || s.(*nth).index != 0 {
+			t.Errorf("missing params should be zero values, got %v and error %v", s, err)
+		}
+	})
+
+	t.Run("rejects invalid params", func(t *testing.T) {
+		tests := map[string]map[string]interface{}{
+			`params: json: unknown field "offset"`:                                                   {"offset": 1.0},
+			"params: json: cannot unmarshal string into Go struct field nthParams.index of type int": {"index": "two"},
+			"index must not be negative":                                                             {"index": -1.0},
+		}
+		for want, params := range tests {
+			if _, err := strategy.New("test-nth", params); err == nil || err.Error() != want {
+				t.Errorf("wrong error. got %v want %s", err, want)
+			}
+		}
+	})
+
+	t.Run("lists registered names for unknown strategy", func(t *testing.T) {
+		_, err := strategy.New("nope", nil)
+
+		var unknown strategy.UnknownError
+		if !errors.As(err, &unknown) {
+			t.Fatalf("expected unknown strategy error, got %v", err)
+		}
+		want := fmt.Sprintf("unknown strategy \"nope\", must be one of %s", strings.Join(strategy.Names(), ", "))
+		if err.Error() != want {
+			t.Errorf("wrong error. got %s want %s", err, want)
+		}
+	})
+
+	t.Run("creates builtin strategies with params", func(t *testing.T) {
+		s, err := strategy.New("least-connection", map[string]interface{}{"by": "connections"})
+		if err != nil {
+			t.Fatalf("error creating strategy: %s", err)
+		}
+		if got := s.(*model.LeastSession).By; got != model.LoadConnections {
+			t.Errorf("wrong load metric. got %s want %s", got, model.LoadConnections)
+		}
+
+		want := `unknown load metric "sessions", must be requests or connections`
+		if _, err := strategy.New("weighted-least-connection", map[string]interface{}{"by": "sessions"}); err == nil || err.Error() != want {
+			t.Errorf("wrong error. got %v want %s", err, want)
+		}
+		if _, err := strategy.New("maglev", map[string]interface{}{"table_size": 1000.0}); err == nil {
+			t.Errorf("expected error for table size which is not prime")
+		}
+	})
+
+	t.Run("panics on duplicate name", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		strategy.Register("round-robin", func(strategy.NoParams) (strategy.Strategy, error) { return nil, nil })
+	})
+}